@@ -0,0 +1,97 @@
+package codec
+
+import "fmt"
+
+var errUnexpectedEnd = fmt.Errorf("unexpected end of frame")
+
+// bitWriter writes MSB first bit fields into a growing buffer
+type bitWriter struct {
+	data  []byte
+	acc   uint64
+	nbits uint
+}
+
+func (w *bitWriter) writeBits(value uint64, n uint) {
+	for n > 0 {
+		take := n
+		if take > 32 {
+			take = 32
+		}
+		n -= take
+		w.acc = w.acc<<take | (value>>n)&(1<<take-1)
+		w.nbits += take
+		for w.nbits >= 8 {
+			w.nbits -= 8
+			w.data = append(w.data, byte(w.acc>>w.nbits))
+		}
+	}
+}
+
+func (w *bitWriter) writeSigned(value int64, n uint) {
+	w.writeBits(uint64(value), n)
+}
+
+func (w *bitWriter) writeUnary(value uint64) {
+	for ; value >= 32; value -= 32 {
+		w.writeBits(0, 32)
+	}
+	w.writeBits(1, uint(value)+1)
+}
+
+func (w *bitWriter) len() int {
+	return len(w.data)*8 + int(w.nbits)
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		return append(w.data, byte(w.acc<<(8-w.nbits)))
+	}
+	return w.data
+}
+
+// bitReader reads MSB first bit fields written by a bitWriter
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) readBits(n uint) (uint64, error) {
+	if r.pos+int(n) > len(r.data)*8 {
+		return 0, errUnexpectedEnd
+	}
+
+	var value uint64
+	for i := uint(0); i < n; i++ {
+		bit := r.data[r.pos>>3] >> (7 - uint(r.pos&7)) & 1
+		value = value<<1 | uint64(bit)
+		r.pos++
+	}
+
+	return value, nil
+}
+
+func (r *bitReader) readSigned(n uint) (int64, error) {
+	value, err := r.readBits(n)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+
+	// Sign extend
+	shift := 64 - n
+	return int64(value<<shift) >> shift, nil
+}
+
+func (r *bitReader) readUnary() (uint64, error) {
+	var value uint64
+	for {
+		if r.pos >= len(r.data)*8 {
+			return 0, errUnexpectedEnd
+		}
+		bit := r.data[r.pos>>3] >> (7 - uint(r.pos&7)) & 1
+		r.pos++
+		if bit == 1 {
+			return value, nil
+		}
+		value++
+	}
+}
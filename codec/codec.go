@@ -0,0 +1,64 @@
+package codec
+
+import (
+	"fmt"
+	"github.com/tuarrep/sounddrop/message"
+	"strings"
+)
+
+// Encoder compress fixed size frames of stereo samples
+type Encoder interface {
+	// FrameSize number of samples expected by each call to Encode
+	FrameSize() int
+	Encode(samples [][2]float64) ([]byte, error)
+}
+
+// Decoder restore stereo samples from compressed frames
+type Decoder interface {
+	Decode(frame []byte) ([][2]float64, error)
+}
+
+// EncoderOptions parameters used to create an encoder
+type EncoderOptions struct {
+	SampleRate   int
+	SampleFormat message.SampleFormat
+	Bitrate      int
+}
+
+// ParseCodec get audio codec from its name. "pcm" means no compression (NO_CODEC)
+func ParseCodec(name string) (message.AudioCodec, error) {
+	if strings.ToLower(name) == "pcm" {
+		return message.AudioCodec_NO_CODEC, nil
+	}
+
+	value, found := message.AudioCodec_value[strings.ToUpper(name)]
+	if !found {
+		return message.AudioCodec_NO_CODEC, fmt.Errorf("unknown audio codec %s", name)
+	}
+
+	return message.AudioCodec(value), nil
+}
+
+// NewEncoder create an encoder for given codec
+func NewEncoder(codec message.AudioCodec, options EncoderOptions) (Encoder, error) {
+	switch codec {
+	case message.AudioCodec_OPUS:
+		return newOpusEncoder(options.SampleRate, options.Bitrate)
+	case message.AudioCodec_FLAC:
+		return newFlacEncoder(options.SampleFormat)
+	default:
+		return nil, fmt.Errorf("no encoder available for codec %v", codec)
+	}
+}
+
+// NewDecoder create a decoder for given codec
+func NewDecoder(codec message.AudioCodec, sampleRate int) (Decoder, error) {
+	switch codec {
+	case message.AudioCodec_OPUS:
+		return newOpusDecoder(sampleRate)
+	case message.AudioCodec_FLAC:
+		return &flacDecoder{}, nil
+	default:
+		return nil, fmt.Errorf("no decoder available for codec %v", codec)
+	}
+}
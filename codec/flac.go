@@ -0,0 +1,622 @@
+package codec
+
+import (
+	"fmt"
+	"github.com/tuarrep/sounddrop/message"
+	"math"
+)
+
+// Frame layout is inspired by FLAC: a small header, the stereo decorrelation mode then one subframe per channel.
+// Subframes are constant, verbatim, fixed polynomial prediction or quantized LPC, residuals are Rice coded.
+
+// Block size used by reference FLAC encoder at fastest settings
+const flacFrameSize = 1152
+
+const (
+	flacIndependent = iota
+	flacLeftSide
+	flacRightSide
+	flacMidSide
+)
+
+const (
+	flacSubframeConstant = iota
+	flacSubframeVerbatim
+	flacSubframeFixed
+	flacSubframeLPC
+)
+
+const (
+	flacMaxFixedOrder     = 4
+	flacMaxLPCOrder       = 12
+	flacLPCPrecision      = 12
+	flacMaxPartitionOrder = 8
+	flacMaxRiceParameter  = 30
+)
+
+type flacEncoder struct {
+	bitsPerSample uint
+}
+
+// newFlacEncoder only accept integer formats, floating point samples cannot be coded losslessly
+func newFlacEncoder(sampleFormat message.SampleFormat) (*flacEncoder, error) {
+	switch sampleFormat {
+	case message.SampleFormat_INT16:
+		return &flacEncoder{bitsPerSample: 16}, nil
+	case message.SampleFormat_INT24:
+		return &flacEncoder{bitsPerSample: 24}, nil
+	default:
+		return nil, fmt.Errorf("flac does not support sample format %v", sampleFormat)
+	}
+}
+
+// flacScale integer value of full scale samples. Integer audio is scaled the way beep decodes and encodes it,
+// so integer files it decoded are coded bit-exact
+func flacScale(bitsPerSample uint) float64 {
+	return float64(int64(1)<<(bitsPerSample-1) - 1)
+}
+
+// flacQuantize convert a sample to an integer of given size, lowest integer being slightly below -1 as with beep
+func flacQuantize(value float64, bitsPerSample uint) int64 {
+	scale := flacScale(bitsPerSample)
+	v := math.Round(value * scale)
+	if v > scale {
+		v = scale
+	} else if v < -scale-1 {
+		v = -scale - 1
+	}
+	return int64(v)
+}
+
+func (e *flacEncoder) FrameSize() int {
+	return flacFrameSize
+}
+
+func (e *flacEncoder) Encode(samples [][2]float64) ([]byte, error) {
+	if len(samples) > math.MaxUint16 {
+		return nil, fmt.Errorf("flac frame is limited to %d samples, got %d", math.MaxUint16, len(samples))
+	}
+
+	left := make([]int64, len(samples))
+	right := make([]int64, len(samples))
+	mid := make([]int64, len(samples))
+	side := make([]int64, len(samples))
+
+	for i, sample := range samples {
+		left[i] = flacQuantize(sample[0], e.bitsPerSample)
+		right[i] = flacQuantize(sample[1], e.bitsPerSample)
+		mid[i] = (left[i] + right[i]) >> 1
+		side[i] = left[i] - right[i]
+	}
+
+	bps := e.bitsPerSample
+	encodedLeft := encodeSubframe(left, bps)
+	encodedRight := encodeSubframe(right, bps)
+	encodedMid := encodeSubframe(mid, bps)
+	encodedSide := encodeSubframe(side, bps+1)
+
+	candidates := [][2]*bitWriter{
+		flacIndependent: {encodedLeft, encodedRight},
+		flacLeftSide:    {encodedLeft, encodedSide},
+		flacRightSide:   {encodedSide, encodedRight},
+		flacMidSide:     {encodedMid, encodedSide},
+	}
+
+	assignment := flacIndependent
+	for i, candidate := range candidates {
+		best := candidates[assignment]
+		if candidate[0].len()+candidate[1].len() < best[0].len()+best[1].len() {
+			assignment = i
+		}
+	}
+
+	w := &bitWriter{}
+	w.writeBits(uint64(bps), 8)
+	w.writeBits(uint64(len(samples)), 16)
+	w.writeBits(uint64(assignment), 2)
+	frame := w.bytes()
+
+	// Subframes are byte aligned so they can be appended without bit shifting
+	for _, subframe := range candidates[assignment] {
+		frame = append(frame, subframe.bytes()...)
+	}
+
+	return frame, nil
+}
+
+type flacDecoder struct{}
+
+func (d *flacDecoder) Decode(frame []byte) ([][2]float64, error) {
+	r := &bitReader{data: frame}
+
+	bps, err := r.readBits(8)
+	if err != nil {
+		return nil, err
+	}
+	if bps < 4 || bps > 32 {
+		return nil, fmt.Errorf("invalid flac bits per sample %d", bps)
+	}
+
+	blockSize, err := r.readBits(16)
+	if err != nil {
+		return nil, err
+	}
+
+	assignment, err := r.readBits(2)
+	if err != nil {
+		return nil, err
+	}
+	r.pos = (r.pos + 7) &^ 7
+
+	var channels [2][]int64
+	for channel := range channels {
+		channelBps := uint(bps)
+		if isSideChannel(assignment, channel) {
+			channelBps++
+		}
+
+		channels[channel], err = decodeSubframe(r, int(blockSize), channelBps)
+		if err != nil {
+			return nil, err
+		}
+		r.pos = (r.pos + 7) &^ 7
+	}
+
+	left, right := channels[0], channels[1]
+	switch assignment {
+	case flacLeftSide:
+		for i := range right {
+			right[i] = left[i] - right[i]
+		}
+	case flacRightSide:
+		for i := range left {
+			left[i] += right[i]
+		}
+	case flacMidSide:
+		for i := range left {
+			m, s := left[i]<<1|right[i]&1, right[i]
+			left[i] = (m + s) >> 1
+			right[i] = (m - s) >> 1
+		}
+	}
+
+	scale := flacScale(uint(bps))
+	samples := make([][2]float64, blockSize)
+	for i := range samples {
+		samples[i] = [2]float64{float64(left[i]) / scale, float64(right[i]) / scale}
+	}
+
+	return samples, nil
+}
+
+// isSideChannel whether channel holds the difference of left and right, which needs one more bit
+func isSideChannel(assignment uint64, channel int) bool {
+	switch assignment {
+	case flacLeftSide, flacMidSide:
+		return channel == 1
+	case flacRightSide:
+		return channel == 0
+	default:
+		return false
+	}
+}
+
+// encodeSubframe estimate the size of every available predictor and encode channel samples with the smallest one
+func encodeSubframe(samples []int64, bps uint) *bitWriter {
+	w := &bitWriter{}
+
+	constant := len(samples) > 0
+	for _, sample := range samples {
+		if sample != samples[0] {
+			constant = false
+			break
+		}
+	}
+
+	if constant {
+		w.writeBits(flacSubframeConstant, 2)
+		w.writeSigned(samples[0], bps)
+		return w
+	}
+
+	bestKind, bestBits := flacSubframeVerbatim, len(samples)*int(bps)
+	var bestOrder int
+	var bestResidual []int64
+	var bestCoefficients []int64
+	var bestShift uint
+
+	for order := 0; order <= flacMaxFixedOrder && order < len(samples); order++ {
+		residual := fixedResidual(samples, order)
+		bits := 3 + order*int(bps) + residualBits(residual, order)
+
+		if bits < bestBits {
+			bestKind, bestBits, bestOrder, bestResidual = flacSubframeFixed, bits, order, residual
+		}
+	}
+
+	for _, order := range []int{2, 4, 8, flacMaxLPCOrder} {
+		if order >= len(samples) {
+			break
+		}
+
+		coefficients, shift, ok := lpcCoefficients(samples, order)
+		if !ok {
+			continue
+		}
+
+		residual := lpcResidual(samples, coefficients, shift)
+		bits := 10 + order*int(bps) + order*flacLPCPrecision + residualBits(residual, order)
+
+		if bits < bestBits {
+			bestKind, bestBits, bestOrder, bestResidual = flacSubframeLPC, bits, order, residual
+			bestCoefficients, bestShift = coefficients, shift
+		}
+	}
+
+	w.writeBits(uint64(bestKind), 2)
+
+	switch bestKind {
+	case flacSubframeVerbatim:
+		for _, sample := range samples {
+			w.writeSigned(sample, bps)
+		}
+		return w
+	case flacSubframeFixed:
+		w.writeBits(uint64(bestOrder), 3)
+	case flacSubframeLPC:
+		w.writeBits(uint64(bestOrder-1), 5)
+		w.writeBits(uint64(bestShift), 5)
+	}
+
+	for _, sample := range samples[:bestOrder] {
+		w.writeSigned(sample, bps)
+	}
+	for _, coefficient := range bestCoefficients {
+		w.writeSigned(coefficient, flacLPCPrecision)
+	}
+	writeResidual(w, bestResidual, bestOrder)
+
+	return w
+}
+
+func decodeSubframe(r *bitReader, blockSize int, bps uint) ([]int64, error) {
+	samples := make([]int64, blockSize)
+
+	kind, err := r.readBits(2)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case flacSubframeConstant:
+		value, err := r.readSigned(bps)
+		if err != nil {
+			return nil, err
+		}
+		for i := range samples {
+			samples[i] = value
+		}
+	case flacSubframeVerbatim:
+		for i := range samples {
+			if samples[i], err = r.readSigned(bps); err != nil {
+				return nil, err
+			}
+		}
+	case flacSubframeFixed:
+		order, err := r.readBits(3)
+		if err != nil {
+			return nil, err
+		}
+		if int(order) > flacMaxFixedOrder || int(order) > blockSize {
+			return nil, fmt.Errorf("invalid fixed predictor order %d", order)
+		}
+		if err = readWarmup(r, samples[:order], bps); err != nil {
+			return nil, err
+		}
+		if err = readResidual(r, samples, int(order)); err != nil {
+			return nil, err
+		}
+		restoreFixed(samples, int(order))
+	case flacSubframeLPC:
+		order, err := r.readBits(5)
+		if err != nil {
+			return nil, err
+		}
+		order++
+		if int(order) > blockSize {
+			return nil, fmt.Errorf("invalid LPC order %d", order)
+		}
+		shift, err := r.readBits(5)
+		if err != nil {
+			return nil, err
+		}
+		if err = readWarmup(r, samples[:order], bps); err != nil {
+			return nil, err
+		}
+		coefficients := make([]int64, order)
+		for i := range coefficients {
+			if coefficients[i], err = r.readSigned(flacLPCPrecision); err != nil {
+				return nil, err
+			}
+		}
+		if err = readResidual(r, samples, int(order)); err != nil {
+			return nil, err
+		}
+		restoreLPC(samples, coefficients, uint(shift))
+	}
+
+	return samples, nil
+}
+
+func readWarmup(r *bitReader, warmup []int64, bps uint) error {
+	var err error
+	for i := range warmup {
+		if warmup[i], err = r.readSigned(bps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fixedResidual residual of FLAC fixed polynomial predictor of given order
+func fixedResidual(samples []int64, order int) []int64 {
+	residual := make([]int64, len(samples)-order)
+	for i := order; i < len(samples); i++ {
+		residual[i-order] = samples[i] - fixedPrediction(samples, i, order)
+	}
+	return residual
+}
+
+func restoreFixed(samples []int64, order int) {
+	for i := order; i < len(samples); i++ {
+		samples[i] += fixedPrediction(samples, i, order)
+	}
+}
+
+func fixedPrediction(s []int64, i int, order int) int64 {
+	switch order {
+	case 1:
+		return s[i-1]
+	case 2:
+		return 2*s[i-1] - s[i-2]
+	case 3:
+		return 3*s[i-1] - 3*s[i-2] + s[i-3]
+	case 4:
+		return 4*s[i-1] - 6*s[i-2] + 4*s[i-3] - s[i-4]
+	default:
+		return 0
+	}
+}
+
+// lpcCoefficients compute quantized LPC coefficients with Levinson-Durbin recursion on Welch windowed samples
+func lpcCoefficients(samples []int64, order int) ([]int64, uint, bool) {
+	n := len(samples)
+	windowed := make([]float64, n)
+	for i, sample := range samples {
+		x := 2*float64(i)/float64(n-1) - 1
+		windowed[i] = float64(sample) * (1 - x*x)
+	}
+
+	autocorrelation := make([]float64, order+1)
+	for lag := range autocorrelation {
+		for i := lag; i < n; i++ {
+			autocorrelation[lag] += windowed[i] * windowed[i-lag]
+		}
+	}
+
+	if autocorrelation[0] == 0 {
+		return nil, 0, false
+	}
+
+	lpc := make([]float64, order)
+	previous := make([]float64, order)
+	e := autocorrelation[0]
+	for i := 0; i < order; i++ {
+		acc := autocorrelation[i+1]
+		for j := 0; j < i; j++ {
+			acc -= lpc[j] * autocorrelation[i-j]
+		}
+		k := acc / e
+		copy(previous, lpc)
+		lpc[i] = k
+		for j := 0; j < i; j++ {
+			lpc[j] = previous[j] - k*previous[i-1-j]
+		}
+		e *= 1 - k*k
+		if e <= 0 {
+			return nil, 0, false
+		}
+	}
+
+	cmax := 0.0
+	for _, c := range lpc {
+		cmax = math.Max(cmax, math.Abs(c))
+	}
+	if cmax == 0 {
+		return nil, 0, false
+	}
+
+	_, log2cmax := math.Frexp(cmax)
+	shift := flacLPCPrecision - 1 - log2cmax
+	if shift < 0 {
+		return nil, 0, false
+	}
+	if shift > 31 {
+		shift = 31
+	}
+
+	// Quantize with error feedback so rounding errors do not accumulate
+	limit := float64(int64(1)<<(flacLPCPrecision-1)) - 1
+	coefficients := make([]int64, order)
+	quantizationError := 0.0
+	for i, c := range lpc {
+		quantizationError += c * float64(int64(1)<<uint(shift))
+		q := math.Max(-limit-1, math.Min(limit, math.Round(quantizationError)))
+		coefficients[i] = int64(q)
+		quantizationError -= q
+	}
+
+	return coefficients, uint(shift), true
+}
+
+func lpcResidual(samples []int64, coefficients []int64, shift uint) []int64 {
+	order := len(coefficients)
+	residual := make([]int64, len(samples)-order)
+	for i := order; i < len(samples); i++ {
+		residual[i-order] = samples[i] - lpcPrediction(samples, i, coefficients, shift)
+	}
+	return residual
+}
+
+func restoreLPC(samples []int64, coefficients []int64, shift uint) {
+	for i := len(coefficients); i < len(samples); i++ {
+		samples[i] += lpcPrediction(samples, i, coefficients, shift)
+	}
+}
+
+func lpcPrediction(s []int64, i int, coefficients []int64, shift uint) int64 {
+	var sum int64
+	for j, c := range coefficients {
+		sum += c * s[i-1-j]
+	}
+	return sum >> shift
+}
+
+// writeResidual Rice code residual in 2^p partitions, the first one being shortened by predictor order
+func writeResidual(w *bitWriter, residual []int64, order int) {
+	folded := foldResidual(residual)
+	partitionOrder, parameters, _ := residualPartitions(folded, order)
+
+	w.writeBits(uint64(partitionOrder), 4)
+	for partition, parameter := range parameters {
+		start, end := partitionBounds(len(residual)+order, order, partitionOrder, partition)
+		w.writeBits(uint64(parameter), 5)
+		for _, u := range folded[start:end] {
+			w.writeUnary(u >> parameter)
+			w.writeBits(u, parameter)
+		}
+	}
+}
+
+// residualBits size in bits of Rice coded residual
+func residualBits(residual []int64, order int) int {
+	_, _, bits := residualPartitions(foldResidual(residual), order)
+	return bits
+}
+
+// foldResidual map signed residual to unsigned values (0, -1, 1, -2, 2...)
+func foldResidual(residual []int64) []uint64 {
+	folded := make([]uint64, len(residual))
+	for i, r := range residual {
+		folded[i] = uint64(r<<1 ^ r>>63)
+	}
+	return folded
+}
+
+// residualPartitions find the partition order and Rice parameters giving the smallest residual
+func residualPartitions(folded []uint64, order int) (int, []uint, int) {
+	n := len(folded) + order
+
+	bestOrder, bestBits := 0, -1
+	var bestParameters []uint
+	for partitionOrder := 0; partitionOrder <= flacMaxPartitionOrder; partitionOrder++ {
+		if n%(1<<uint(partitionOrder)) != 0 || n>>uint(partitionOrder) <= order {
+			break
+		}
+
+		bits := 4
+		parameters := make([]uint, 1<<uint(partitionOrder))
+		for partition := range parameters {
+			start, end := partitionBounds(n, order, partitionOrder, partition)
+			parameter, cost := riceParameter(folded[start:end])
+			parameters[partition] = parameter
+			bits += 5 + cost
+		}
+
+		if bestBits < 0 || bits < bestBits {
+			bestOrder, bestBits, bestParameters = partitionOrder, bits, parameters
+		}
+	}
+
+	return bestOrder, bestParameters, bestBits
+}
+
+func readResidual(r *bitReader, samples []int64, order int) error {
+	n := len(samples)
+
+	partitionOrder, err := r.readBits(4)
+	if err != nil {
+		return err
+	}
+	if partitionOrder > flacMaxPartitionOrder || n%(1<<partitionOrder) != 0 || n>>partitionOrder < order {
+		return fmt.Errorf("invalid residual partition order %d", partitionOrder)
+	}
+
+	for partition := 0; partition < 1<<partitionOrder; partition++ {
+		parameter, err := r.readBits(5)
+		if err != nil {
+			return err
+		}
+		if parameter > flacMaxRiceParameter {
+			return fmt.Errorf("invalid rice parameter %d", parameter)
+		}
+
+		start, end := partitionBounds(n, order, int(partitionOrder), partition)
+		for i := start; i < end; i++ {
+			high, err := r.readUnary()
+			if err != nil {
+				return err
+			}
+			low, err := r.readBits(uint(parameter))
+			if err != nil {
+				return err
+			}
+			u := high<<parameter | low
+			samples[order+i] = int64(u>>1) ^ -int64(u&1)
+		}
+	}
+
+	return nil
+}
+
+// partitionBounds residual indexes covered by a partition
+func partitionBounds(n int, order int, partitionOrder int, partition int) (int, int) {
+	size := n >> uint(partitionOrder)
+	start := partition*size - order
+	if partition == 0 {
+		start = 0
+	}
+	return start, (partition+1)*size - order
+}
+
+// riceParameter find the cheapest Rice parameter for values and return it with its cost in bits
+func riceParameter(values []uint64) (uint, int) {
+	var sum uint64
+	for _, u := range values {
+		sum += u
+	}
+
+	// Optimal parameter is close to log2 of the mean value
+	estimate := 0
+	if len(values) > 0 && sum > uint64(len(values)) {
+		estimate = int(math.Log2(float64(sum) / float64(len(values))))
+	}
+
+	bestParameter, bestCost := uint(0), -1
+	for parameter := estimate - 1; parameter <= estimate+2; parameter++ {
+		if parameter < 0 || parameter > flacMaxRiceParameter {
+			continue
+		}
+
+		cost := 0
+		for _, u := range values {
+			cost += int(u>>uint(parameter)) + 1 + parameter
+		}
+
+		if bestCost < 0 || cost < bestCost {
+			bestParameter, bestCost = uint(parameter), cost
+		}
+	}
+
+	return bestParameter, bestCost
+}
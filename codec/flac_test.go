@@ -0,0 +1,95 @@
+package codec
+
+import (
+	"github.com/tuarrep/sounddrop/message"
+	"math/rand"
+	"testing"
+)
+
+// integerSamples build frames of integer audio scaled like beep does, the way integer files reach the encoder
+func integerSamples(count int, bits uint, value func(i int, channel int, scale int64) int64) [][2]float64 {
+	scale := int64(1)<<(bits-1) - 1
+	samples := make([][2]float64, count)
+	for i := range samples {
+		for channel := 0; channel < 2; channel++ {
+			samples[i][channel] = float64(value(i, channel, scale)) / float64(scale)
+		}
+	}
+
+	return samples
+}
+
+func TestFlacRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	cases := map[string]struct {
+		count int
+		value func(i int, channel int, scale int64) int64
+	}{
+		"random": {flacFrameSize, func(i int, channel int, scale int64) int64 {
+			return random.Int63n(2*scale+2) - scale - 1
+		}},
+		"silence": {flacFrameSize, func(i int, channel int, scale int64) int64 {
+			return 0
+		}},
+		"full scale": {flacFrameSize, func(i int, channel int, scale int64) int64 {
+			if (i+channel)%2 == 0 {
+				return scale
+			}
+			return -scale - 1
+		}},
+		"one sample": {1, func(i int, channel int, scale int64) int64 {
+			return scale
+		}},
+		"short": {7, func(i int, channel int, scale int64) int64 {
+			return random.Int63n(2*scale+2) - scale - 1
+		}},
+		"odd length": {100, func(i int, channel int, scale int64) int64 {
+			return int64(i*(channel+1)) - 50
+		}},
+	}
+
+	formats := map[message.SampleFormat]uint{
+		message.SampleFormat_INT16: 16,
+		message.SampleFormat_INT24: 24,
+	}
+
+	for format, bits := range formats {
+		for name, c := range cases {
+			samples := integerSamples(c.count, bits, c.value)
+
+			encoder, err := newFlacEncoder(format)
+			if err != nil {
+				t.Fatalf("%s %d bits: %v", name, bits, err)
+			}
+
+			frame, err := encoder.Encode(samples)
+			if err != nil {
+				t.Fatalf("%s %d bits: encode: %v", name, bits, err)
+			}
+
+			decoded, err := (&flacDecoder{}).Decode(frame)
+			if err != nil {
+				t.Fatalf("%s %d bits: decode: %v", name, bits, err)
+			}
+
+			if len(decoded) != len(samples) {
+				t.Fatalf("%s %d bits: decoded %d samples, want %d", name, bits, len(decoded), len(samples))
+			}
+
+			for i := range samples {
+				if decoded[i] != samples[i] {
+					t.Fatalf("%s %d bits: sample %d is %v, want %v", name, bits, i, decoded[i], samples[i])
+				}
+			}
+		}
+	}
+}
+
+func TestFlacRejectFloatingPoint(t *testing.T) {
+	for _, format := range []message.SampleFormat{message.SampleFormat_FLOAT32, message.SampleFormat_DOUBLE} {
+		if _, err := newFlacEncoder(format); err == nil {
+			t.Errorf("flac encoder accepted %v samples", format)
+		}
+	}
+}
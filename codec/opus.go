@@ -0,0 +1,130 @@
+package codec
+
+import (
+	"fmt"
+	"gopkg.in/hraban/opus.v2"
+)
+
+// Opus only accepts 2.5, 5, 10, 20, 40 or 60ms frames. 20ms is the recommended value for music
+const opusFramesPerSecond = 50
+
+// Largest packet an Opus encoder can produce for one frame
+const opusMaxPacketSize = 1275 * 3
+
+// opusSampleRate rate audio is coded at: Opus only supports a few rates, audio at other rates is resampled to 48kHz
+func opusSampleRate(sampleRate int) int {
+	switch sampleRate {
+	case 8000, 12000, 16000, 24000, 48000:
+		return sampleRate
+	default:
+		return 48000
+	}
+}
+
+type opusEncoder struct {
+	encoder   *opus.Encoder
+	frameSize int
+	resampler *frameResampler
+	pcm       []float32
+	packet    []byte
+}
+
+func newOpusEncoder(sampleRate int, bitrate int) (*opusEncoder, error) {
+	// Frames must last exactly as long once resampled
+	if sampleRate <= 0 || sampleRate%opusFramesPerSecond != 0 {
+		return nil, fmt.Errorf("opus needs a sample rate multiple of %dHz, got %dHz", opusFramesPerSecond, sampleRate)
+	}
+
+	codedRate := opusSampleRate(sampleRate)
+	encoder, err := opus.NewEncoder(codedRate, 2, opus.AppAudio)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create opus encoder at %dHz: %v", codedRate, err)
+	}
+
+	if err = encoder.SetBitrate(bitrate); err != nil {
+		return nil, fmt.Errorf("invalid opus bitrate %d: %v", bitrate, err)
+	}
+
+	e := &opusEncoder{encoder: encoder, frameSize: sampleRate / opusFramesPerSecond, pcm: make([]float32, 2*codedRate/opusFramesPerSecond), packet: make([]byte, opusMaxPacketSize)}
+	if codedRate != sampleRate {
+		e.resampler = newFrameResampler(sampleRate, codedRate)
+	}
+
+	return e, nil
+}
+
+func (e *opusEncoder) FrameSize() int {
+	return e.frameSize
+}
+
+func (e *opusEncoder) Encode(samples [][2]float64) ([]byte, error) {
+	if len(samples) > e.frameSize {
+		return nil, fmt.Errorf("opus frame is limited to %d samples, got %d", e.frameSize, len(samples))
+	}
+
+	for i := range e.pcm {
+		e.pcm[i] = 0
+	}
+
+	if e.resampler != nil {
+		// Last frame of a stream may be short, it is padded with silence like when no resampling is needed
+		padded := make([][2]float64, e.frameSize)
+		copy(padded, samples)
+		samples = e.resampler.convert(padded)
+	}
+
+	for i, sample := range samples {
+		e.pcm[2*i] = float32(sample[0])
+		e.pcm[2*i+1] = float32(sample[1])
+	}
+
+	n, err := e.encoder.EncodeFloat32(e.pcm, e.packet)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, n)
+	copy(frame, e.packet[:n])
+
+	return frame, nil
+}
+
+type opusDecoder struct {
+	decoder   *opus.Decoder
+	resampler *frameResampler
+	pcm       []float32
+}
+
+func newOpusDecoder(sampleRate int) (*opusDecoder, error) {
+	codedRate := opusSampleRate(sampleRate)
+	decoder, err := opus.NewDecoder(codedRate, 2)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create opus decoder at %dHz: %v", codedRate, err)
+	}
+
+	// A packet holds at most 120ms of audio
+	d := &opusDecoder{decoder: decoder, pcm: make([]float32, 2*codedRate*120/1000)}
+	if codedRate != sampleRate {
+		d.resampler = newFrameResampler(codedRate, sampleRate)
+	}
+
+	return d, nil
+}
+
+func (d *opusDecoder) Decode(frame []byte) ([][2]float64, error) {
+	n, err := d.decoder.DecodeFloat32(frame, d.pcm)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([][2]float64, n)
+	for i := range samples {
+		samples[i] = [2]float64{float64(d.pcm[2*i]), float64(d.pcm[2*i+1])}
+	}
+
+	if d.resampler != nil {
+		return d.resampler.convert(samples), nil
+	}
+
+	return samples, nil
+}
@@ -0,0 +1,112 @@
+package codec
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/tuarrep/sounddrop/message"
+)
+
+// FramePayload audio payload of a stream frame, the part protected by parity packets
+func FramePayload(msg proto.Message) ([]byte, error) {
+	switch m := msg.(type) {
+	case *message.StreamData:
+		if m.SampleFormat != message.SampleFormat_DOUBLE || len(m.Samples) > 0 {
+			return m.Samples, nil
+		}
+
+		samples, err := DecodeStreamData(m)
+		if err != nil {
+			return nil, err
+		}
+		return EncodePCM(samples, message.SampleFormat_DOUBLE)
+	case *message.EncodedStreamData:
+		return m.Frame, nil
+	default:
+		return nil, fmt.Errorf("unexpected stream message %T", msg)
+	}
+}
+
+// NewParity compute the XOR parity packet protecting consecutive frames of a stream
+func NewParity(frames []proto.Message) (*message.StreamParity, error) {
+	parity := &message.StreamParity{}
+
+	for i, frame := range frames {
+		payload, err := FramePayload(frame)
+		if err != nil {
+			return nil, err
+		}
+
+		var sequence uint64
+		switch m := frame.(type) {
+		case *message.StreamData:
+			parity.StreamId, sequence, parity.SampleFormat = m.StreamId, m.Sequence, m.SampleFormat
+			parity.NextAts = append(parity.NextAts, m.NextAt)
+		case *message.EncodedStreamData:
+			parity.StreamId, sequence, parity.Codec = m.StreamId, m.Sequence, m.Codec
+			parity.NextAts = append(parity.NextAts, m.NextAt)
+		}
+
+		if i == 0 {
+			parity.FirstSequence = sequence
+		} else if sequence != parity.FirstSequence+uint64(i) {
+			return nil, fmt.Errorf("parity frames are not consecutive (%d after %d)", sequence, parity.FirstSequence+uint64(i-1))
+		}
+
+		parity.Lengths = append(parity.Lengths, uint32(len(payload)))
+		parity.Parity = xorInto(parity.Parity, payload)
+	}
+
+	return parity, nil
+}
+
+// RecoverFrame rebuild the only frame missing (nil payload) from a parity group
+func RecoverFrame(parity *message.StreamParity, payloads [][]byte) (proto.Message, error) {
+	if len(payloads) != len(parity.Lengths) || len(parity.NextAts) != len(parity.Lengths) {
+		return nil, fmt.Errorf("parity group of %d frames does not match %d payloads", len(parity.Lengths), len(payloads))
+	}
+
+	missing := -1
+	recovered := append([]byte{}, parity.Parity...)
+	for i, payload := range payloads {
+		if payload == nil {
+			if missing >= 0 {
+				return nil, fmt.Errorf("more than one frame missing in parity group")
+			}
+			missing = i
+			continue
+		}
+		recovered = xorInto(recovered, payload)
+	}
+
+	if missing < 0 {
+		return nil, fmt.Errorf("no frame missing in parity group")
+	}
+
+	length := int(parity.Lengths[missing])
+	if length > len(recovered) {
+		return nil, fmt.Errorf("invalid recovered frame length %d", length)
+	}
+	recovered = recovered[:length]
+
+	sequence := parity.FirstSequence + uint64(missing)
+	nextAt := parity.NextAts[missing]
+
+	if parity.Codec == message.AudioCodec_NO_CODEC {
+		return &message.StreamData{SampleFormat: parity.SampleFormat, Samples: recovered, NextAt: nextAt, StreamId: parity.StreamId, Sequence: sequence}, nil
+	}
+
+	return &message.EncodedStreamData{Codec: parity.Codec, Frame: recovered, NextAt: nextAt, StreamId: parity.StreamId, Sequence: sequence}, nil
+}
+
+// xorInto XOR payload into parity, growing parity when payload is longer
+func xorInto(parity []byte, payload []byte) []byte {
+	for len(parity) < len(payload) {
+		parity = append(parity, 0)
+	}
+
+	for i, b := range payload {
+		parity[i] ^= b
+	}
+
+	return parity
+}
@@ -0,0 +1,101 @@
+package codec
+
+import (
+	"bytes"
+	"github.com/golang/protobuf/proto"
+	"github.com/tuarrep/sounddrop/message"
+	"testing"
+)
+
+// parityGroup build consecutive encoded frames of different lengths
+func parityGroup(count int) []proto.Message {
+	frames := make([]proto.Message, count)
+	for i := range frames {
+		frames[i] = &message.EncodedStreamData{Codec: message.AudioCodec_FLAC, Frame: bytes.Repeat([]byte{byte(i + 1)}, 10+i), NextAt: int64(i * 100), StreamId: 7, Sequence: uint64(5 + i)}
+	}
+
+	return frames
+}
+
+func TestParityRecoverEachFrame(t *testing.T) {
+	frames := parityGroup(4)
+	parity, err := NewParity(frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for missing := range frames {
+		payloads := make([][]byte, len(frames))
+		for i, frame := range frames {
+			if i != missing {
+				payloads[i], _ = FramePayload(frame)
+			}
+		}
+
+		recovered, err := RecoverFrame(parity, payloads)
+		if err != nil {
+			t.Fatalf("frame %d: %v", missing, err)
+		}
+		if !proto.Equal(recovered, frames[missing]) {
+			t.Errorf("frame %d recovered as %v, want %v", missing, recovered, frames[missing])
+		}
+	}
+}
+
+func TestParityRecoverPCM(t *testing.T) {
+	frames := make([]proto.Message, 3)
+	for i := range frames {
+		msg := &message.StreamData{NextAt: int64(i), StreamId: 3, Sequence: uint64(i)}
+		if err := EncodeStreamData(msg, [][2]float64{{0.5, float64(i) / 4}}, message.SampleFormat_INT16); err != nil {
+			t.Fatal(err)
+		}
+		frames[i] = msg
+	}
+
+	parity, err := NewParity(frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payloads := make([][]byte, len(frames))
+	payloads[0], _ = FramePayload(frames[0])
+	payloads[2], _ = FramePayload(frames[2])
+
+	recovered, err := RecoverFrame(parity, payloads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(recovered, frames[1]) {
+		t.Errorf("frame recovered as %v, want %v", recovered, frames[1])
+	}
+}
+
+func TestParityRejectInvalidGroup(t *testing.T) {
+	frames := parityGroup(3)
+	parity, err := NewParity(frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payloads := make([][]byte, len(frames))
+	for i, frame := range frames {
+		payloads[i], _ = FramePayload(frame)
+	}
+	if _, err := RecoverFrame(parity, payloads); err == nil {
+		t.Error("recovered a frame from a complete group")
+	}
+
+	payloads[0], payloads[1] = nil, nil
+	if _, err := RecoverFrame(parity, payloads); err == nil {
+		t.Error("recovered a frame from a group missing two")
+	}
+
+	if _, err := RecoverFrame(parity, payloads[:2]); err == nil {
+		t.Error("recovered a frame from a group of the wrong size")
+	}
+
+	frames[1], frames[2] = frames[2], frames[1]
+	if _, err := NewParity(frames); err == nil {
+		t.Error("parity computed for frames out of order")
+	}
+}
@@ -0,0 +1,145 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/tuarrep/sounddrop/message"
+	"math"
+	"strings"
+)
+
+const (
+	int16Scale = 1 << 15
+	int24Scale = 1 << 23
+)
+
+// ParseSampleFormat get sample format from its name (case insensitive)
+func ParseSampleFormat(name string) (message.SampleFormat, error) {
+	value, found := message.SampleFormat_value[strings.ToUpper(name)]
+	if !found {
+		return message.SampleFormat_DOUBLE, fmt.Errorf("unknown sample format %s", name)
+	}
+
+	return message.SampleFormat(value), nil
+}
+
+// FrameSize number of bytes used by one stereo frame in given sample format
+func FrameSize(format message.SampleFormat) int {
+	switch format {
+	case message.SampleFormat_INT16:
+		return 2 * 2
+	case message.SampleFormat_INT24:
+		return 2 * 3
+	case message.SampleFormat_FLOAT32:
+		return 2 * 4
+	default:
+		return 2 * 8
+	}
+}
+
+// EncodePCM pack stereo samples as interleaved little endian PCM
+func EncodePCM(samples [][2]float64, format message.SampleFormat) ([]byte, error) {
+	frameSize := FrameSize(format)
+	data := make([]byte, len(samples)*frameSize)
+
+	for i, sample := range samples {
+		frame := data[i*frameSize:]
+		for channel, value := range sample {
+			switch format {
+			case message.SampleFormat_INT16:
+				binary.LittleEndian.PutUint16(frame[channel*2:], uint16(quantize(value, int16Scale)))
+			case message.SampleFormat_INT24:
+				v := quantize(value, int24Scale)
+				frame[channel*3] = byte(v)
+				frame[channel*3+1] = byte(v >> 8)
+				frame[channel*3+2] = byte(v >> 16)
+			case message.SampleFormat_FLOAT32:
+				binary.LittleEndian.PutUint32(frame[channel*4:], math.Float32bits(float32(value)))
+			case message.SampleFormat_DOUBLE:
+				binary.LittleEndian.PutUint64(frame[channel*8:], math.Float64bits(value))
+			default:
+				return nil, fmt.Errorf("unsupported sample format %v", format)
+			}
+		}
+	}
+
+	return data, nil
+}
+
+// DecodePCM unpack interleaved little endian PCM into stereo samples
+func DecodePCM(data []byte, format message.SampleFormat) ([][2]float64, error) {
+	frameSize := FrameSize(format)
+	if len(data)%frameSize != 0 {
+		return nil, fmt.Errorf("PCM payload of %d bytes is not a multiple of %s frame size", len(data), format)
+	}
+
+	samples := make([][2]float64, len(data)/frameSize)
+
+	for i := range samples {
+		frame := data[i*frameSize:]
+		for channel := 0; channel < 2; channel++ {
+			switch format {
+			case message.SampleFormat_INT16:
+				samples[i][channel] = float64(int16(binary.LittleEndian.Uint16(frame[channel*2:]))) / int16Scale
+			case message.SampleFormat_INT24:
+				v := int32(frame[channel*3]) | int32(frame[channel*3+1])<<8 | int32(int8(frame[channel*3+2]))<<16
+				samples[i][channel] = float64(v) / int24Scale
+			case message.SampleFormat_FLOAT32:
+				samples[i][channel] = float64(math.Float32frombits(binary.LittleEndian.Uint32(frame[channel*4:])))
+			case message.SampleFormat_DOUBLE:
+				samples[i][channel] = math.Float64frombits(binary.LittleEndian.Uint64(frame[channel*8:]))
+			default:
+				return nil, fmt.Errorf("unsupported sample format %v", format)
+			}
+		}
+	}
+
+	return samples, nil
+}
+
+// EncodeStreamData fill message payload with samples using given sample format (DOUBLE uses legacy fields)
+func EncodeStreamData(msg *message.StreamData, samples [][2]float64, format message.SampleFormat) error {
+	msg.SampleFormat = format
+
+	if format == message.SampleFormat_DOUBLE {
+		msg.SamplesLeft = make([]float64, len(samples))
+		msg.SamplesRight = make([]float64, len(samples))
+		for i, sample := range samples {
+			msg.SamplesLeft[i] = sample[0]
+			msg.SamplesRight[i] = sample[1]
+		}
+		return nil
+	}
+
+	data, err := EncodePCM(samples, format)
+	msg.Samples = data
+	return err
+}
+
+// DecodeStreamData get samples carried by message, whatever its encoding
+func DecodeStreamData(msg *message.StreamData) ([][2]float64, error) {
+	if msg.SampleFormat != message.SampleFormat_DOUBLE || len(msg.Samples) > 0 {
+		return DecodePCM(msg.Samples, msg.SampleFormat)
+	}
+
+	if len(msg.SamplesLeft) != len(msg.SamplesRight) {
+		return nil, fmt.Errorf("channels length mismatch (left=%d, right=%d)", len(msg.SamplesLeft), len(msg.SamplesRight))
+	}
+
+	samples := make([][2]float64, len(msg.SamplesLeft))
+	for i := range samples {
+		samples[i] = [2]float64{msg.SamplesLeft[i], msg.SamplesRight[i]}
+	}
+
+	return samples, nil
+}
+
+func quantize(value float64, scale float64) int32 {
+	v := math.Round(value * scale)
+	if v > scale-1 {
+		v = scale - 1
+	} else if v < -scale {
+		v = -scale
+	}
+	return int32(v)
+}
@@ -0,0 +1,69 @@
+package codec
+
+// frameResampler convert consecutive frames between two sample rates with cubic interpolation.
+// Output is delayed by two input samples, so each frame is converted with samples already received only
+type frameResampler struct {
+	from    int
+	to      int
+	history [3][2]float64
+}
+
+func newFrameResampler(from int, to int) *frameResampler {
+	return &frameResampler{from: from, to: to}
+}
+
+// convert a frame of n samples to a frame of n*to/from samples
+func (r *frameResampler) convert(samples [][2]float64) [][2]float64 {
+	converted := make([][2]float64, len(samples)*r.to/r.from)
+
+	for j := range converted {
+		// Position in input samples, numerator and denominator are kept integer so frames line up exactly
+		numerator := j*r.from - 2*r.to
+		index := floorDiv(numerator, r.to)
+		t := float64(numerator-index*r.to) / float64(r.to)
+
+		for channel := 0; channel < 2; channel++ {
+			p0 := r.sample(samples, index-1)[channel]
+			p1 := r.sample(samples, index)[channel]
+			p2 := r.sample(samples, index+1)[channel]
+			p3 := r.sample(samples, index+2)[channel]
+
+			// Catmull-Rom spline through the four nearest samples
+			converted[j][channel] = p1 + 0.5*t*(p2-p0+t*(2*p0-5*p1+4*p2-p3+t*(3*(p1-p2)+p3-p0)))
+		}
+	}
+
+	for _, sample := range samples {
+		r.history[0], r.history[1], r.history[2] = r.history[1], r.history[2], sample
+	}
+
+	return converted
+}
+
+// sample input sample at index, negative indexes are the last samples of previous frames
+func (r *frameResampler) sample(samples [][2]float64, index int) [2]float64 {
+	if index < 0 {
+		if len(r.history)+index < 0 {
+			return r.history[0]
+		}
+		return r.history[len(r.history)+index]
+	}
+
+	if index >= len(samples) {
+		if len(samples) == 0 {
+			return r.history[len(r.history)-1]
+		}
+		return samples[len(samples)-1]
+	}
+
+	return samples[index]
+}
+
+func floorDiv(a int, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+
+	return q
+}
@@ -0,0 +1,81 @@
+package codec
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFrameResamplerLength(t *testing.T) {
+	cases := []struct {
+		from, to, samples, converted int
+	}{
+		{44100, 48000, 882, 960},
+		{48000, 44100, 960, 882},
+		{22050, 48000, 441, 960},
+		{48000, 48000, 960, 960},
+	}
+
+	for _, c := range cases {
+		r := newFrameResampler(c.from, c.to)
+		if got := len(r.convert(make([][2]float64, c.samples))); got != c.converted {
+			t.Errorf("%d samples from %dHz to %dHz gave %d samples, want %d", c.samples, c.from, c.to, got, c.converted)
+		}
+	}
+}
+
+func TestFrameResamplerConstant(t *testing.T) {
+	r := newFrameResampler(44100, 48000)
+	frame := make([][2]float64, 882)
+	for i := range frame {
+		frame[i] = [2]float64{0.5, -0.25}
+	}
+
+	// First samples are interpolated from silence before the stream started
+	r.convert(frame)
+	for i, sample := range r.convert(frame) {
+		if math.Abs(sample[0]-0.5) > 1e-12 || math.Abs(sample[1]+0.25) > 1e-12 {
+			t.Fatalf("sample %d is %v, want constant signal", i, sample)
+		}
+	}
+}
+
+func TestFrameResamplerRoundTrip(t *testing.T) {
+	up := newFrameResampler(44100, 48000)
+	down := newFrameResampler(48000, 44100)
+
+	const frameSize = 882
+	const frames = 10
+	const frequency = 440.0
+
+	signal := func(position float64) float64 {
+		return 0.8 * math.Sin(2*math.Pi*frequency*position/44100)
+	}
+
+	var output [][2]float64
+	for f := 0; f < frames; f++ {
+		frame := make([][2]float64, frameSize)
+		for i := range frame {
+			value := signal(float64(f*frameSize + i))
+			frame[i] = [2]float64{value, -value}
+		}
+
+		converted := down.convert(up.convert(frame))
+		if len(converted) != frameSize {
+			t.Fatalf("frame %d came back with %d samples, want %d", f, len(converted), frameSize)
+		}
+		output = append(output, converted...)
+	}
+
+	// Each conversion delays signal by two of its input samples
+	delay := 2 + 2*44100.0/48000
+	worst := 0.0
+	for i := frameSize; i < len(output); i++ {
+		expected := signal(float64(i) - delay)
+		worst = math.Max(worst, math.Abs(output[i][0]-expected))
+		worst = math.Max(worst, math.Abs(output[i][1]+expected))
+	}
+
+	if worst > 1e-3 {
+		t.Errorf("round trip error is %g", worst)
+	}
+}
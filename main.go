@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/golang/protobuf/proto"
 	"github.com/thejerf/suture"
+	"github.com/tuarrep/sounddrop/message"
 	"github.com/tuarrep/sounddrop/service"
 	"github.com/tuarrep/sounddrop/util"
 	"os"
@@ -18,15 +19,22 @@ func main() {
 	log := util.GetContextLogger("main.go", "main")
 
 	log.Info("Starting main process...")
-	myID := util.GetMyID()
+	identity, err := util.GetMyIdentity()
+	util.CheckError(err, log)
+	myID := identity.DeviceID()
 
 	log.Info("I'm known on mesh by: ", myID.String())
+	log.Info("Device fingerprint: ", identity.Fingerprint())
 
 	stop := make(chan os.Signal)
 	signal.Notify(stop, os.Interrupt)
 
 	sb := util.GetServiceBag()
 	sb.DeviceID = myID
+	sb.Identity = identity
+	message.SetSender(myID.String())
+	message.SetMeshID(sb.Config.Mesh.ID)
+	message.SetMeshKey(sb.Config.Mesh.Key)
 
 	supervisor := suture.NewSimple("supervisor")
 
@@ -36,9 +44,22 @@ func main() {
 	server := &service.Server{Messenger: messenger}
 	supervisor.Add(server)
 
+	if sb.Config.Discover.DNSSD {
+		dnssd := &service.DNSSD{Messenger: messenger}
+		supervisor.Add(dnssd)
+	}
+
 	mesher := &service.Mesher{Messenger: messenger}
 	supervisor.Add(mesher)
 
+	if sb.Config.Mesh.Console != "" {
+		console := &service.Console{Messenger: messenger}
+		supervisor.Add(console)
+	}
+
+	clock := &service.Clock{Messenger: messenger}
+	supervisor.Add(clock)
+
 	if sb.Config.Streamer.AutoStart {
 		streamer := &service.Streamer{Messenger: messenger}
 		supervisor.Add(streamer)
@@ -25,14 +25,119 @@ const (
 // of the legacy proto package is being used.
 const _ = proto.ProtoPackageIsVersion4
 
+type SampleFormat int32
+
+const (
+	SampleFormat_DOUBLE  SampleFormat = 0
+	SampleFormat_INT16   SampleFormat = 1
+	SampleFormat_INT24   SampleFormat = 2
+	SampleFormat_FLOAT32 SampleFormat = 3
+)
+
+// Enum value maps for SampleFormat.
+var (
+	SampleFormat_name = map[int32]string{
+		0: "DOUBLE",
+		1: "INT16",
+		2: "INT24",
+		3: "FLOAT32",
+	}
+	SampleFormat_value = map[string]int32{
+		"DOUBLE":  0,
+		"INT16":   1,
+		"INT24":   2,
+		"FLOAT32": 3,
+	}
+)
+
+func (x SampleFormat) Enum() *SampleFormat {
+	p := new(SampleFormat)
+	*p = x
+	return p
+}
+
+func (x SampleFormat) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (SampleFormat) Descriptor() protoreflect.EnumDescriptor {
+	return file_message_audio_proto_enumTypes[0].Descriptor()
+}
+
+func (SampleFormat) Type() protoreflect.EnumType {
+	return &file_message_audio_proto_enumTypes[0]
+}
+
+func (x SampleFormat) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use SampleFormat.Descriptor instead.
+func (SampleFormat) EnumDescriptor() ([]byte, []int) {
+	return file_message_audio_proto_rawDescGZIP(), []int{0}
+}
+
+type AudioCodec int32
+
+const (
+	AudioCodec_NO_CODEC AudioCodec = 0
+	AudioCodec_OPUS     AudioCodec = 1
+	AudioCodec_FLAC     AudioCodec = 2
+)
+
+// Enum value maps for AudioCodec.
+var (
+	AudioCodec_name = map[int32]string{
+		0: "NO_CODEC",
+		1: "OPUS",
+		2: "FLAC",
+	}
+	AudioCodec_value = map[string]int32{
+		"NO_CODEC": 0,
+		"OPUS":     1,
+		"FLAC":     2,
+	}
+)
+
+func (x AudioCodec) Enum() *AudioCodec {
+	p := new(AudioCodec)
+	*p = x
+	return p
+}
+
+func (x AudioCodec) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (AudioCodec) Descriptor() protoreflect.EnumDescriptor {
+	return file_message_audio_proto_enumTypes[1].Descriptor()
+}
+
+func (AudioCodec) Type() protoreflect.EnumType {
+	return &file_message_audio_proto_enumTypes[1]
+}
+
+func (x AudioCodec) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use AudioCodec.Descriptor instead.
+func (AudioCodec) EnumDescriptor() ([]byte, []int) {
+	return file_message_audio_proto_rawDescGZIP(), []int{1}
+}
+
 type StreamData struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	SamplesLeft  []float64 `protobuf:"fixed64,1,rep,packed,name=samplesLeft,proto3" json:"samplesLeft,omitempty"`
-	SamplesRight []float64 `protobuf:"fixed64,2,rep,packed,name=samplesRight,proto3" json:"samplesRight,omitempty"`
-	NextAt       int64     `protobuf:"varint,3,opt,name=nextAt,proto3" json:"nextAt,omitempty"`
+	SamplesLeft  []float64    `protobuf:"fixed64,1,rep,packed,name=samplesLeft,proto3" json:"samplesLeft,omitempty"`
+	SamplesRight []float64    `protobuf:"fixed64,2,rep,packed,name=samplesRight,proto3" json:"samplesRight,omitempty"`
+	NextAt       int64        `protobuf:"varint,3,opt,name=nextAt,proto3" json:"nextAt,omitempty"`
+	SampleFormat SampleFormat `protobuf:"varint,4,opt,name=sampleFormat,proto3,enum=message.SampleFormat" json:"sampleFormat,omitempty"`
+	Samples      []byte       `protobuf:"bytes,5,opt,name=samples,proto3" json:"samples,omitempty"`
+	StreamId     uint32       `protobuf:"varint,6,opt,name=streamId,proto3" json:"streamId,omitempty"`
+	Sequence     uint64       `protobuf:"varint,7,opt,name=sequence,proto3" json:"sequence,omitempty"`
 }
 
 func (x *StreamData) Reset() {
@@ -88,19 +193,395 @@ func (x *StreamData) GetNextAt() int64 {
 	return 0
 }
 
+func (x *StreamData) GetSampleFormat() SampleFormat {
+	if x != nil {
+		return x.SampleFormat
+	}
+	return SampleFormat_DOUBLE
+}
+
+func (x *StreamData) GetSamples() []byte {
+	if x != nil {
+		return x.Samples
+	}
+	return nil
+}
+
+func (x *StreamData) GetStreamId() uint32 {
+	if x != nil {
+		return x.StreamId
+	}
+	return 0
+}
+
+func (x *StreamData) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+type EncodedStreamData struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Codec    AudioCodec `protobuf:"varint,1,opt,name=codec,proto3,enum=message.AudioCodec" json:"codec,omitempty"`
+	Frame    []byte     `protobuf:"bytes,2,opt,name=frame,proto3" json:"frame,omitempty"`
+	NextAt   int64      `protobuf:"varint,3,opt,name=nextAt,proto3" json:"nextAt,omitempty"`
+	StreamId uint32     `protobuf:"varint,4,opt,name=streamId,proto3" json:"streamId,omitempty"`
+	Sequence uint64     `protobuf:"varint,5,opt,name=sequence,proto3" json:"sequence,omitempty"`
+}
+
+func (x *EncodedStreamData) Reset() {
+	*x = EncodedStreamData{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_message_audio_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *EncodedStreamData) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EncodedStreamData) ProtoMessage() {}
+
+func (x *EncodedStreamData) ProtoReflect() protoreflect.Message {
+	mi := &file_message_audio_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EncodedStreamData.ProtoReflect.Descriptor instead.
+func (*EncodedStreamData) Descriptor() ([]byte, []int) {
+	return file_message_audio_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *EncodedStreamData) GetCodec() AudioCodec {
+	if x != nil {
+		return x.Codec
+	}
+	return AudioCodec_NO_CODEC
+}
+
+func (x *EncodedStreamData) GetFrame() []byte {
+	if x != nil {
+		return x.Frame
+	}
+	return nil
+}
+
+func (x *EncodedStreamData) GetNextAt() int64 {
+	if x != nil {
+		return x.NextAt
+	}
+	return 0
+}
+
+func (x *EncodedStreamData) GetStreamId() uint32 {
+	if x != nil {
+		return x.StreamId
+	}
+	return 0
+}
+
+func (x *EncodedStreamData) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+type StreamParity struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	StreamId      uint32       `protobuf:"varint,1,opt,name=streamId,proto3" json:"streamId,omitempty"`
+	FirstSequence uint64       `protobuf:"varint,2,opt,name=firstSequence,proto3" json:"firstSequence,omitempty"`
+	NextAts       []int64      `protobuf:"varint,3,rep,packed,name=nextAts,proto3" json:"nextAts,omitempty"`
+	Lengths       []uint32     `protobuf:"varint,4,rep,packed,name=lengths,proto3" json:"lengths,omitempty"`
+	Parity        []byte       `protobuf:"bytes,5,opt,name=parity,proto3" json:"parity,omitempty"`
+	SampleFormat  SampleFormat `protobuf:"varint,6,opt,name=sampleFormat,proto3,enum=message.SampleFormat" json:"sampleFormat,omitempty"`
+	Codec         AudioCodec   `protobuf:"varint,7,opt,name=codec,proto3,enum=message.AudioCodec" json:"codec,omitempty"`
+}
+
+func (x *StreamParity) Reset() {
+	*x = StreamParity{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_message_audio_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StreamParity) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamParity) ProtoMessage() {}
+
+func (x *StreamParity) ProtoReflect() protoreflect.Message {
+	mi := &file_message_audio_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamParity.ProtoReflect.Descriptor instead.
+func (*StreamParity) Descriptor() ([]byte, []int) {
+	return file_message_audio_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *StreamParity) GetStreamId() uint32 {
+	if x != nil {
+		return x.StreamId
+	}
+	return 0
+}
+
+func (x *StreamParity) GetFirstSequence() uint64 {
+	if x != nil {
+		return x.FirstSequence
+	}
+	return 0
+}
+
+func (x *StreamParity) GetNextAts() []int64 {
+	if x != nil {
+		return x.NextAts
+	}
+	return nil
+}
+
+func (x *StreamParity) GetLengths() []uint32 {
+	if x != nil {
+		return x.Lengths
+	}
+	return nil
+}
+
+func (x *StreamParity) GetParity() []byte {
+	if x != nil {
+		return x.Parity
+	}
+	return nil
+}
+
+func (x *StreamParity) GetSampleFormat() SampleFormat {
+	if x != nil {
+		return x.SampleFormat
+	}
+	return SampleFormat_DOUBLE
+}
+
+func (x *StreamParity) GetCodec() AudioCodec {
+	if x != nil {
+		return x.Codec
+	}
+	return AudioCodec_NO_CODEC
+}
+
+type SequenceRange struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	First uint64 `protobuf:"varint,1,opt,name=first,proto3" json:"first,omitempty"`
+	Last  uint64 `protobuf:"varint,2,opt,name=last,proto3" json:"last,omitempty"`
+}
+
+func (x *SequenceRange) Reset() {
+	*x = SequenceRange{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_message_audio_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SequenceRange) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SequenceRange) ProtoMessage() {}
+
+func (x *SequenceRange) ProtoReflect() protoreflect.Message {
+	mi := &file_message_audio_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SequenceRange.ProtoReflect.Descriptor instead.
+func (*SequenceRange) Descriptor() ([]byte, []int) {
+	return file_message_audio_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SequenceRange) GetFirst() uint64 {
+	if x != nil {
+		return x.First
+	}
+	return 0
+}
+
+func (x *SequenceRange) GetLast() uint64 {
+	if x != nil {
+		return x.Last
+	}
+	return 0
+}
+
+type StreamNack struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	StreamId   uint32           `protobuf:"varint,1,opt,name=streamId,proto3" json:"streamId,omitempty"`
+	DeviceName string           `protobuf:"bytes,2,opt,name=deviceName,proto3" json:"deviceName,omitempty"`
+	Missing    []*SequenceRange `protobuf:"bytes,3,rep,name=missing,proto3" json:"missing,omitempty"`
+}
+
+func (x *StreamNack) Reset() {
+	*x = StreamNack{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_message_audio_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StreamNack) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamNack) ProtoMessage() {}
+
+func (x *StreamNack) ProtoReflect() protoreflect.Message {
+	mi := &file_message_audio_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamNack.ProtoReflect.Descriptor instead.
+func (*StreamNack) Descriptor() ([]byte, []int) {
+	return file_message_audio_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *StreamNack) GetStreamId() uint32 {
+	if x != nil {
+		return x.StreamId
+	}
+	return 0
+}
+
+func (x *StreamNack) GetDeviceName() string {
+	if x != nil {
+		return x.DeviceName
+	}
+	return ""
+}
+
+func (x *StreamNack) GetMissing() []*SequenceRange {
+	if x != nil {
+		return x.Missing
+	}
+	return nil
+}
+
 var File_message_audio_proto protoreflect.FileDescriptor
 
 var file_message_audio_proto_rawDesc = []byte{
 	0x0a, 0x13, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2f, 0x61, 0x75, 0x64, 0x69, 0x6f, 0x2e,
-	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x6a,
-	0x0a, 0x0a, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x44, 0x61, 0x74, 0x61, 0x12, 0x20, 0x0a, 0x0b,
-	0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x73, 0x4c, 0x65, 0x66, 0x74, 0x18, 0x01, 0x20, 0x03, 0x28,
-	0x01, 0x52, 0x0b, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x73, 0x4c, 0x65, 0x66, 0x74, 0x12, 0x22,
-	0x0a, 0x0c, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x73, 0x52, 0x69, 0x67, 0x68, 0x74, 0x18, 0x02,
-	0x20, 0x03, 0x28, 0x01, 0x52, 0x0c, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x73, 0x52, 0x69, 0x67,
-	0x68, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x6e, 0x65, 0x78, 0x74, 0x41, 0x74, 0x18, 0x03, 0x20, 0x01,
-	0x28, 0x03, 0x52, 0x06, 0x6e, 0x65, 0x78, 0x74, 0x41, 0x74, 0x42, 0x09, 0x5a, 0x07, 0x6d, 0x65,
-	0x73, 0x73, 0x61, 0x67, 0x65, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0xf7,
+	0x01, 0x0a, 0x0a, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x44, 0x61, 0x74, 0x61, 0x12, 0x20, 0x0a,
+	0x0b, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x73, 0x4c, 0x65, 0x66, 0x74, 0x18, 0x01, 0x20, 0x03,
+	0x28, 0x01, 0x52, 0x0b, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x73, 0x4c, 0x65, 0x66, 0x74, 0x12,
+	0x22, 0x0a, 0x0c, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x73, 0x52, 0x69, 0x67, 0x68, 0x74, 0x18,
+	0x02, 0x20, 0x03, 0x28, 0x01, 0x52, 0x0c, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x73, 0x52, 0x69,
+	0x67, 0x68, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x6e, 0x65, 0x78, 0x74, 0x41, 0x74, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x06, 0x6e, 0x65, 0x78, 0x74, 0x41, 0x74, 0x12, 0x39, 0x0a, 0x0c, 0x73,
+	0x61, 0x6d, 0x70, 0x6c, 0x65, 0x46, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x0e, 0x32, 0x15, 0x2e, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2e, 0x53, 0x61, 0x6d, 0x70,
+	0x6c, 0x65, 0x46, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x52, 0x0c, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65,
+	0x46, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65,
+	0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x07, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x73,
+	0x12, 0x1a, 0x0a, 0x08, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x49, 0x64, 0x18, 0x06, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x08, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x49, 0x64, 0x12, 0x1a, 0x0a, 0x08,
+	0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x18, 0x07, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08,
+	0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x22, 0xa4, 0x01, 0x0a, 0x11, 0x45, 0x6e, 0x63,
+	0x6f, 0x64, 0x65, 0x64, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x44, 0x61, 0x74, 0x61, 0x12, 0x29,
+	0x0a, 0x05, 0x63, 0x6f, 0x64, 0x65, 0x63, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x13, 0x2e,
+	0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2e, 0x41, 0x75, 0x64, 0x69, 0x6f, 0x43, 0x6f, 0x64,
+	0x65, 0x63, 0x52, 0x05, 0x63, 0x6f, 0x64, 0x65, 0x63, 0x12, 0x14, 0x0a, 0x05, 0x66, 0x72, 0x61,
+	0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x66, 0x72, 0x61, 0x6d, 0x65, 0x12,
+	0x16, 0x0a, 0x06, 0x6e, 0x65, 0x78, 0x74, 0x41, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x06, 0x6e, 0x65, 0x78, 0x74, 0x41, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x74, 0x72, 0x65, 0x61,
+	0x6d, 0x49, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x73, 0x74, 0x72, 0x65, 0x61,
+	0x6d, 0x49, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x18,
+	0x05, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x22,
+	0x82, 0x02, 0x0a, 0x0c, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x50, 0x61, 0x72, 0x69, 0x74, 0x79,
+	0x12, 0x1a, 0x0a, 0x08, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x08, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x49, 0x64, 0x12, 0x24, 0x0a, 0x0d,
+	0x66, 0x69, 0x72, 0x73, 0x74, 0x53, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x04, 0x52, 0x0d, 0x66, 0x69, 0x72, 0x73, 0x74, 0x53, 0x65, 0x71, 0x75, 0x65, 0x6e,
+	0x63, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x6e, 0x65, 0x78, 0x74, 0x41, 0x74, 0x73, 0x18, 0x03, 0x20,
+	0x03, 0x28, 0x03, 0x52, 0x07, 0x6e, 0x65, 0x78, 0x74, 0x41, 0x74, 0x73, 0x12, 0x18, 0x0a, 0x07,
+	0x6c, 0x65, 0x6e, 0x67, 0x74, 0x68, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0d, 0x52, 0x07, 0x6c,
+	0x65, 0x6e, 0x67, 0x74, 0x68, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x70, 0x61, 0x72, 0x69, 0x74, 0x79,
+	0x18, 0x05, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x70, 0x61, 0x72, 0x69, 0x74, 0x79, 0x12, 0x39,
+	0x0a, 0x0c, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x46, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x18, 0x06,
+	0x20, 0x01, 0x28, 0x0e, 0x32, 0x15, 0x2e, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2e, 0x53,
+	0x61, 0x6d, 0x70, 0x6c, 0x65, 0x46, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x52, 0x0c, 0x73, 0x61, 0x6d,
+	0x70, 0x6c, 0x65, 0x46, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x12, 0x29, 0x0a, 0x05, 0x63, 0x6f, 0x64,
+	0x65, 0x63, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x13, 0x2e, 0x6d, 0x65, 0x73, 0x73, 0x61,
+	0x67, 0x65, 0x2e, 0x41, 0x75, 0x64, 0x69, 0x6f, 0x43, 0x6f, 0x64, 0x65, 0x63, 0x52, 0x05, 0x63,
+	0x6f, 0x64, 0x65, 0x63, 0x22, 0x39, 0x0a, 0x0d, 0x53, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65,
+	0x52, 0x61, 0x6e, 0x67, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x66, 0x69, 0x72, 0x73, 0x74, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x04, 0x52, 0x05, 0x66, 0x69, 0x72, 0x73, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x6c,
+	0x61, 0x73, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x04, 0x6c, 0x61, 0x73, 0x74, 0x22,
+	0x7a, 0x0a, 0x0a, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x4e, 0x61, 0x63, 0x6b, 0x12, 0x1a, 0x0a,
+	0x08, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x08, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x49, 0x64, 0x12, 0x1e, 0x0a, 0x0a, 0x64, 0x65, 0x76,
+	0x69, 0x63, 0x65, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x64,
+	0x65, 0x76, 0x69, 0x63, 0x65, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x30, 0x0a, 0x07, 0x6d, 0x69, 0x73,
+	0x73, 0x69, 0x6e, 0x67, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x6d, 0x65, 0x73,
+	0x73, 0x61, 0x67, 0x65, 0x2e, 0x53, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x52, 0x61, 0x6e,
+	0x67, 0x65, 0x52, 0x07, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6e, 0x67, 0x2a, 0x3d, 0x0a, 0x0c, 0x53,
+	0x61, 0x6d, 0x70, 0x6c, 0x65, 0x46, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x12, 0x0a, 0x0a, 0x06, 0x44,
+	0x4f, 0x55, 0x42, 0x4c, 0x45, 0x10, 0x00, 0x12, 0x09, 0x0a, 0x05, 0x49, 0x4e, 0x54, 0x31, 0x36,
+	0x10, 0x01, 0x12, 0x09, 0x0a, 0x05, 0x49, 0x4e, 0x54, 0x32, 0x34, 0x10, 0x02, 0x12, 0x0b, 0x0a,
+	0x07, 0x46, 0x4c, 0x4f, 0x41, 0x54, 0x33, 0x32, 0x10, 0x03, 0x2a, 0x2e, 0x0a, 0x0a, 0x41, 0x75,
+	0x64, 0x69, 0x6f, 0x43, 0x6f, 0x64, 0x65, 0x63, 0x12, 0x0c, 0x0a, 0x08, 0x4e, 0x4f, 0x5f, 0x43,
+	0x4f, 0x44, 0x45, 0x43, 0x10, 0x00, 0x12, 0x08, 0x0a, 0x04, 0x4f, 0x50, 0x55, 0x53, 0x10, 0x01,
+	0x12, 0x08, 0x0a, 0x04, 0x46, 0x4c, 0x41, 0x43, 0x10, 0x02, 0x42, 0x26, 0x5a, 0x24, 0x67, 0x69,
+	0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x74, 0x75, 0x61, 0x72, 0x72, 0x65, 0x70,
+	0x2f, 0x73, 0x6f, 0x75, 0x6e, 0x64, 0x64, 0x72, 0x6f, 0x70, 0x2f, 0x6d, 0x65, 0x73, 0x73, 0x61,
+	0x67, 0x65, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -115,16 +596,28 @@ func file_message_audio_proto_rawDescGZIP() []byte {
 	return file_message_audio_proto_rawDescData
 }
 
-var file_message_audio_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_message_audio_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_message_audio_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
 var file_message_audio_proto_goTypes = []interface{}{
-	(*StreamData)(nil), // 0: message.StreamData
+	(SampleFormat)(0),         // 0: message.SampleFormat
+	(AudioCodec)(0),           // 1: message.AudioCodec
+	(*StreamData)(nil),        // 2: message.StreamData
+	(*EncodedStreamData)(nil), // 3: message.EncodedStreamData
+	(*StreamParity)(nil),      // 4: message.StreamParity
+	(*SequenceRange)(nil),     // 5: message.SequenceRange
+	(*StreamNack)(nil),        // 6: message.StreamNack
 }
 var file_message_audio_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	0, // 0: message.StreamData.sampleFormat:type_name -> message.SampleFormat
+	1, // 1: message.EncodedStreamData.codec:type_name -> message.AudioCodec
+	0, // 2: message.StreamParity.sampleFormat:type_name -> message.SampleFormat
+	1, // 3: message.StreamParity.codec:type_name -> message.AudioCodec
+	5, // 4: message.StreamNack.missing:type_name -> message.SequenceRange
+	5, // [5:5] is the sub-list for method output_type
+	5, // [5:5] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
 }
 
 func init() { file_message_audio_proto_init() }
@@ -145,19 +638,68 @@ func file_message_audio_proto_init() {
 				return nil
 			}
 		}
+		file_message_audio_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*EncodedStreamData); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_message_audio_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StreamParity); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_message_audio_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SequenceRange); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_message_audio_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StreamNack); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_message_audio_proto_rawDesc,
-			NumEnums:      0,
-			NumMessages:   1,
+			NumEnums:      2,
+			NumMessages:   5,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
 		GoTypes:           file_message_audio_proto_goTypes,
 		DependencyIndexes: file_message_audio_proto_depIdxs,
+		EnumInfos:         file_message_audio_proto_enumTypes,
 		MessageInfos:      file_message_audio_proto_msgTypes,
 	}.Build()
 	File_message_audio_proto = out.File
@@ -0,0 +1,59 @@
+package message
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+)
+
+// Size of the HMAC-SHA256 appended to packets when a mesh key is set
+const macSize = sha256.Size
+
+var meshKey []byte
+
+// SetMeshKey set the secret shared by mesh devices to authenticate every packet. Empty secret disables authentication
+func SetMeshKey(secret string) {
+	if secret == "" {
+		meshKey = nil
+		return
+	}
+
+	key := sha256.Sum256([]byte(secret))
+	meshKey = key[:]
+}
+
+// Authenticated whether packets are authenticated with a mesh key
+func Authenticated() bool {
+	return meshKey != nil
+}
+
+// seal append packet authentication code when a mesh key is set
+func seal(data []byte) []byte {
+	if meshKey == nil {
+		return data
+	}
+
+	mac := hmac.New(sha256.New, meshKey)
+	mac.Write(data)
+	return mac.Sum(data)
+}
+
+// unseal verify and remove packet authentication code when a mesh key is set
+func unseal(buffer []byte) ([]byte, error) {
+	if meshKey == nil {
+		return buffer, nil
+	}
+
+	if len(buffer) <= macSize {
+		return nil, fmt.Errorf("unauthenticated packet")
+	}
+
+	data := buffer[:len(buffer)-macSize]
+	mac := hmac.New(sha256.New, meshKey)
+	mac.Write(data)
+	if !hmac.Equal(mac.Sum(nil), buffer[len(data):]) {
+		return nil, fmt.Errorf("invalid packet authentication code")
+	}
+
+	return data, nil
+}
@@ -0,0 +1,232 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.21.0
+// 	protoc        v3.11.4
+// source: message/clock.proto
+
+package message
+
+import (
+	proto "github.com/golang/protobuf/proto"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// This is a compile-time assertion that a sufficiently up-to-date version
+// of the legacy proto package is being used.
+const _ = proto.ProtoPackageIsVersion4
+
+type ClockRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Originate int64 `protobuf:"varint,1,opt,name=originate,proto3" json:"originate,omitempty"`
+}
+
+func (x *ClockRequest) Reset() {
+	*x = ClockRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_message_clock_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ClockRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClockRequest) ProtoMessage() {}
+
+func (x *ClockRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_message_clock_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClockRequest.ProtoReflect.Descriptor instead.
+func (*ClockRequest) Descriptor() ([]byte, []int) {
+	return file_message_clock_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ClockRequest) GetOriginate() int64 {
+	if x != nil {
+		return x.Originate
+	}
+	return 0
+}
+
+type ClockResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Originate int64 `protobuf:"varint,1,opt,name=originate,proto3" json:"originate,omitempty"`
+	Receive   int64 `protobuf:"varint,2,opt,name=receive,proto3" json:"receive,omitempty"`
+	Transmit  int64 `protobuf:"varint,3,opt,name=transmit,proto3" json:"transmit,omitempty"`
+}
+
+func (x *ClockResponse) Reset() {
+	*x = ClockResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_message_clock_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ClockResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClockResponse) ProtoMessage() {}
+
+func (x *ClockResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_message_clock_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClockResponse.ProtoReflect.Descriptor instead.
+func (*ClockResponse) Descriptor() ([]byte, []int) {
+	return file_message_clock_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ClockResponse) GetOriginate() int64 {
+	if x != nil {
+		return x.Originate
+	}
+	return 0
+}
+
+func (x *ClockResponse) GetReceive() int64 {
+	if x != nil {
+		return x.Receive
+	}
+	return 0
+}
+
+func (x *ClockResponse) GetTransmit() int64 {
+	if x != nil {
+		return x.Transmit
+	}
+	return 0
+}
+
+var File_message_clock_proto protoreflect.FileDescriptor
+
+var file_message_clock_proto_rawDesc = []byte{
+	0x0a, 0x13, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2f, 0x63, 0x6c, 0x6f, 0x63, 0x6b, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x2c,
+	0x0a, 0x0c, 0x43, 0x6c, 0x6f, 0x63, 0x6b, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1c,
+	0x0a, 0x09, 0x6f, 0x72, 0x69, 0x67, 0x69, 0x6e, 0x61, 0x74, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x09, 0x6f, 0x72, 0x69, 0x67, 0x69, 0x6e, 0x61, 0x74, 0x65, 0x22, 0x63, 0x0a, 0x0d,
+	0x43, 0x6c, 0x6f, 0x63, 0x6b, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1c, 0x0a,
+	0x09, 0x6f, 0x72, 0x69, 0x67, 0x69, 0x6e, 0x61, 0x74, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x09, 0x6f, 0x72, 0x69, 0x67, 0x69, 0x6e, 0x61, 0x74, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x72,
+	0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x72, 0x65,
+	0x63, 0x65, 0x69, 0x76, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x6d, 0x69,
+	0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x6d, 0x69,
+	0x74, 0x42, 0x26, 0x5a, 0x24, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f,
+	0x74, 0x75, 0x61, 0x72, 0x72, 0x65, 0x70, 0x2f, 0x73, 0x6f, 0x75, 0x6e, 0x64, 0x64, 0x72, 0x6f,
+	0x70, 0x2f, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x33,
+}
+
+var (
+	file_message_clock_proto_rawDescOnce sync.Once
+	file_message_clock_proto_rawDescData = file_message_clock_proto_rawDesc
+)
+
+func file_message_clock_proto_rawDescGZIP() []byte {
+	file_message_clock_proto_rawDescOnce.Do(func() {
+		file_message_clock_proto_rawDescData = protoimpl.X.CompressGZIP(file_message_clock_proto_rawDescData)
+	})
+	return file_message_clock_proto_rawDescData
+}
+
+var file_message_clock_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_message_clock_proto_goTypes = []interface{}{
+	(*ClockRequest)(nil),  // 0: message.ClockRequest
+	(*ClockResponse)(nil), // 1: message.ClockResponse
+}
+var file_message_clock_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_message_clock_proto_init() }
+func file_message_clock_proto_init() {
+	if File_message_clock_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_message_clock_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ClockRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_message_clock_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ClockResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_message_clock_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_message_clock_proto_goTypes,
+		DependencyIndexes: file_message_clock_proto_depIdxs,
+		MessageInfos:      file_message_clock_proto_msgTypes,
+	}.Build()
+	File_message_clock_proto = out.File
+	file_message_clock_proto_rawDesc = nil
+	file_message_clock_proto_goTypes = nil
+	file_message_clock_proto_depIdxs = nil
+}
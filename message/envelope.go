@@ -0,0 +1,111 @@
+package message
+
+import (
+	"github.com/golang/protobuf/proto"
+	"sync/atomic"
+	"time"
+)
+
+// ProtocolVersion version of the envelope wrapping every message sent on mesh
+const ProtocolVersion uint32 = 1
+
+// FlagRelayed envelope flag set on messages forwarded by a relay, they must not be forwarded again
+const FlagRelayed uint32 = 1
+
+var sender string
+var meshID string
+
+// Sequence starts from clock so it keeps growing across restarts, as expected by replay protection
+var sequence = uint64(time.Now().UnixNano())
+
+// SetSender set the device ID written in the envelope of every sent message
+func SetSender(deviceID string) {
+	sender = deviceID
+}
+
+// SetMeshID set the mesh written in the envelope of every sent message
+func SetMeshID(id string) {
+	meshID = id
+}
+
+func newEnvelope(opCode byte, payload []byte) *Envelope {
+	return &Envelope{
+		Version:  ProtocolVersion,
+		Sender:   sender,
+		Sequence: atomic.AddUint64(&sequence, 1),
+		SentAt:   time.Now().UnixNano(),
+		OpCode:   uint32(opCode),
+		Payload:  payload,
+		MeshId:   meshID,
+	}
+}
+
+// Packet message received from mesh along with its envelope.
+// It can go through messenger as its message, receivers get both back with Unwrap
+type Packet struct {
+	Envelope *Envelope
+	Message  proto.Message
+}
+
+// Reset resets the wrapped message
+func (p *Packet) Reset() {
+	p.Message.Reset()
+}
+
+// String returns the string representation of the wrapped message
+func (p *Packet) String() string {
+	return p.Message.String()
+}
+
+// ProtoMessage marks packet as a message
+func (*Packet) ProtoMessage() {}
+
+// Relay get bytes buffer of a received packet to forward it, keeping its original envelope
+func (p *Packet) Relay() ([]byte, error) {
+	envelope := proto.Clone(p.Envelope).(*Envelope)
+	envelope.Flags |= FlagRelayed
+
+	data, err := proto.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	return seal(data), nil
+}
+
+// Restamp give a new sending time to a buffer built by ToBuffer or Relay, so it can be sent again without being taken for a stale packet.
+// Messages of this device also get a new sequence, not to be taken for a replay
+func Restamp(buffer []byte) ([]byte, error) {
+	data, err := unseal(buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := &Envelope{}
+	if err := proto.Unmarshal(data, envelope); err != nil {
+		return nil, err
+	}
+
+	// Sequence of messages relayed from other devices belongs to their sender, copies received through other relays are
+	// recognized by it
+	if envelope.Sender == sender {
+		envelope.Sequence = atomic.AddUint64(&sequence, 1)
+	}
+	envelope.SentAt = time.Now().UnixNano()
+
+	data, err = proto.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	return seal(data), nil
+}
+
+// Unwrap get message and its envelope from a received packet. Envelope is nil for messages sent by local services
+func Unwrap(msg proto.Message) (proto.Message, *Envelope) {
+	if packet, ok := msg.(*Packet); ok {
+		return packet.Message, packet.Envelope
+	}
+
+	return msg, nil
+}
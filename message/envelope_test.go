@@ -0,0 +1,46 @@
+package message
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestamp(t *testing.T) {
+	SetMeshKey("secret")
+	defer SetMeshKey("")
+
+	for _, own := range []bool{true, false} {
+		SetSender("other")
+		data, err := ToBuffer(&DeviceStatus{Id: "other"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if own {
+			SetSender("other")
+		} else {
+			SetSender("device")
+		}
+
+		before, err := FromBuffer(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(time.Millisecond)
+		restamped, err := Restamp(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		after, err := FromBuffer(restamped)
+		if err != nil {
+			t.Fatalf("restamped packet is invalid: %v", err)
+		}
+		if after.Envelope.SentAt <= before.Envelope.SentAt {
+			t.Errorf("own %v: sending time not updated", own)
+		}
+		if own == (after.Envelope.Sequence == before.Envelope.Sequence) {
+			t.Errorf("own %v: sequence %d became %d", own, before.Envelope.Sequence, after.Envelope.Sequence)
+		}
+	}
+}
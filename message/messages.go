@@ -1,6 +1,7 @@
 package message
 
 import (
+	"errors"
 	"fmt"
 	"github.com/golang/protobuf/proto"
 	"reflect"
@@ -16,39 +17,117 @@ const ServiceNumber uint32 = 0xECC377BC
 
 // Messages opCodes
 const (
-	AnnounceMessage     = 0x00
-	DeviceStatusMessage = 0x10
-	StreamDataMessage   = 0x20
-	PeerOnlineMessage   = 0xF0
-	PeerOfflineMessage  = 0xF1
-	WriteRequestMessage = 0xF2
+	AnnounceMessage          = 0x00
+	PeerListMessage          = 0x01
+	DeviceStatusMessage      = 0x10
+	PairChallengeMessage     = 0x11
+	PairResponseMessage      = 0x12
+	StreamDataMessage        = 0x20
+	EncodedStreamDataMessage = 0x21
+	StreamParityMessage      = 0x22
+	StreamNackMessage        = 0x23
+	FragmentMessage          = 0x30
+	SealedMessage            = 0x31
+	HandshakeMessage         = 0x32
+	ClockRequestMessage      = 0x40
+	ClockResponseMessage     = 0x41
+	PeerOnlineMessage        = 0xF0
+	PeerOfflineMessage       = 0xF1
+	WriteRequestMessage      = 0xF2
+	PeerDiscoveredMessage    = 0xF3
+	AcceptedDevicesMessage   = 0xF4
+	PairRequestMessage       = 0xF5
+	PairResultMessage        = 0xF6
+	ClockOffsetMessage       = 0xF7
 )
 
-// FromBuffer get message instance from raw bytes buffer
-func FromBuffer(buffer []byte) (proto.Message, error) {
+// ErrLegacyPacket returned for packets of devices older than envelopes, which only wrote an opcode before the message.
+// They cannot authenticate themselves and must be upgraded to join mesh
+var ErrLegacyPacket = errors.New("packet of a device older than protocol version 1, it must be upgraded")
+
+// FromBuffer get message instance and its envelope from raw bytes buffer
+func FromBuffer(buffer []byte) (*Packet, error) {
 	if len(buffer) < 1 {
-		return nil, nil
+		return nil, fmt.Errorf("empty buffer")
+	}
+
+	if isLegacy(buffer) {
+		return nil, ErrLegacyPacket
+	}
+
+	buffer, err := unseal(buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := &Envelope{}
+	if err := proto.Unmarshal(buffer, envelope); err != nil {
+		return nil, err
+	}
+
+	if envelope.Version != ProtocolVersion {
+		return nil, fmt.Errorf("unsupported protocol version %d", envelope.Version)
 	}
 
 	var message proto.Message
 
-	opCode := buffer[0]
+	opCode := envelope.OpCode
 	switch opCode {
 	case AnnounceMessage:
 		message = &Announce{}
+	case PeerListMessage:
+		message = &PeerList{}
 	case DeviceStatusMessage:
 		message = &DeviceStatus{}
+	case PairChallengeMessage:
+		message = &PairChallenge{}
+	case PairResponseMessage:
+		message = &PairResponse{}
 	case StreamDataMessage:
 		message = &StreamData{}
+	case EncodedStreamDataMessage:
+		message = &EncodedStreamData{}
+	case StreamParityMessage:
+		message = &StreamParity{}
+	case StreamNackMessage:
+		message = &StreamNack{}
+	case FragmentMessage:
+		message = &Fragment{}
+	case SealedMessage:
+		message = &Sealed{}
+	case HandshakeMessage:
+		message = &Handshake{}
+	case ClockRequestMessage:
+		message = &ClockRequest{}
+	case ClockResponseMessage:
+		message = &ClockResponse{}
 	default:
 		return nil, fmt.Errorf("invalid OP code %d", opCode)
 	}
 
-	err := proto.Unmarshal(buffer[1:], message)
-	return message, err
+	err = proto.Unmarshal(envelope.Payload, message)
+	return &Packet{Envelope: envelope, Message: message}, err
+}
+
+// isLegacy whether buffer holds an opcode followed by a message, as sent before envelopes.
+// Envelopes start with their version field, which no legacy opcode is mistaken for
+func isLegacy(buffer []byte) bool {
+	var message proto.Message
+	switch buffer[0] {
+	case AnnounceMessage:
+		message = &Announce{}
+	case DeviceStatusMessage:
+		message = &DeviceStatus{}
+	case StreamDataMessage:
+		message = &StreamData{}
+	default:
+		return false
+	}
+
+	return proto.Unmarshal(buffer[1:], message) == nil
 }
 
-// ToBuffer get bytes buffer from message instance
+// ToBuffer get bytes buffer from message instance wrapped in an envelope, authenticated when a mesh key is set
 func ToBuffer(message proto.Message) ([]byte, error) {
 	opcode, err := FindOpCode(message)
 	if err != nil {
@@ -60,26 +139,65 @@ func ToBuffer(message proto.Message) ([]byte, error) {
 		return nil, err
 	}
 
-	return append([]byte{opcode}, data...), nil
+	data, err = proto.Marshal(newEnvelope(opcode, data))
+	if err != nil {
+		return nil, err
+	}
+
+	return seal(data), nil
 }
 
 // FindOpCode message opCode from message type
 func FindOpCode(message proto.Message) (byte, error) {
 	var opcode byte
 
-	switch message.(type) {
+	switch m := message.(type) {
+	case *Packet:
+		return FindOpCode(m.Message)
 	case *Announce:
 		opcode = AnnounceMessage
+	case *PeerList:
+		opcode = PeerListMessage
 	case *DeviceStatus:
 		opcode = DeviceStatusMessage
+	case *PairChallenge:
+		opcode = PairChallengeMessage
+	case *PairResponse:
+		opcode = PairResponseMessage
 	case *StreamData:
 		opcode = StreamDataMessage
+	case *EncodedStreamData:
+		opcode = EncodedStreamDataMessage
+	case *StreamParity:
+		opcode = StreamParityMessage
+	case *StreamNack:
+		opcode = StreamNackMessage
+	case *Fragment:
+		opcode = FragmentMessage
+	case *Sealed:
+		opcode = SealedMessage
+	case *Handshake:
+		opcode = HandshakeMessage
+	case *ClockRequest:
+		opcode = ClockRequestMessage
+	case *ClockResponse:
+		opcode = ClockResponseMessage
 	case *PeerOnline:
 		opcode = PeerOnlineMessage
 	case *PeerOffline:
 		opcode = PeerOfflineMessage
 	case *WriteRequest:
 		opcode = WriteRequestMessage
+	case *PeerDiscovered:
+		opcode = PeerDiscoveredMessage
+	case *AcceptedDevices:
+		opcode = AcceptedDevicesMessage
+	case *PairRequest:
+		opcode = PairRequestMessage
+	case *PairResult:
+		opcode = PairResultMessage
+	case *ClockOffset:
+		opcode = ClockOffsetMessage
 	default:
 		return 0x00, fmt.Errorf("invalid message type %s", reflect.TypeOf(message).String())
 	}
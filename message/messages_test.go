@@ -0,0 +1,47 @@
+package message
+
+import (
+	"github.com/golang/protobuf/proto"
+	"testing"
+)
+
+func TestFromBufferRejectLegacy(t *testing.T) {
+	legacy := []proto.Message{
+		&Announce{ServiceNumber: ServiceNumber, DeviceName: "device"},
+		&DeviceStatus{Id: "device"},
+		&StreamData{SamplesLeft: []float64{0.5}, SamplesRight: []float64{-0.5}, NextAt: 1},
+	}
+
+	for _, msg := range legacy {
+		opcode, err := FindOpCode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := proto.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := FromBuffer(append([]byte{opcode}, data...)); err != ErrLegacyPacket {
+			t.Errorf("legacy %T gave %v", msg, err)
+		}
+	}
+}
+
+func TestFromBufferEnvelope(t *testing.T) {
+	SetSender("device")
+	for _, msg := range []proto.Message{&Announce{ServiceNumber: ServiceNumber, DeviceName: "device"}, &DeviceStatus{}, &StreamData{}} {
+		data, err := ToBuffer(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		packet, err := FromBuffer(data)
+		if err != nil {
+			t.Fatalf("%T: %v", msg, err)
+		}
+		if !proto.Equal(packet.Message, msg) || packet.Envelope.Sender != "device" {
+			t.Errorf("%T came back as %v from %q", msg, packet.Message, packet.Envelope.Sender)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package service
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/sirupsen/logrus"
+	"github.com/tuarrep/sounddrop/message"
+	"github.com/tuarrep/sounddrop/util"
+	"time"
+)
+
+// Delay between two clock requests to a peer
+const clockSyncInterval = 1 * time.Second
+
+// Number of last exchanges the least delayed one is picked from, others were slowed down by queues
+const clockFilterSize = 8
+
+// Number of filtered offsets drift is estimated from
+const clockHistorySize = 64
+
+// Shortest time span over which drift is estimated
+const minDriftSpan = 30 * time.Second
+
+// Largest drift accepted, quartz clocks are way more accurate
+const maxDrift = 500e-6
+
+// Longest round trip accepted for a clock exchange
+const maxClockDelay = 1 * time.Second
+
+// clockSample offset of a peer clock measured by one request and response exchange
+type clockSample struct {
+	at     int64
+	offset int64
+	delay  int64
+}
+
+// clockEstimate offset and drift of a peer clock relative to ours
+type clockEstimate struct {
+	samples []clockSample
+	history []clockSample
+	offset  int64
+	drift   float64
+	at      int64
+}
+
+// add record an exchange and update estimate. Returns whether estimate changed
+func (e *clockEstimate) add(sample clockSample) bool {
+	e.samples = append(e.samples, sample)
+	if len(e.samples) > clockFilterSize {
+		e.samples = e.samples[1:]
+	}
+
+	best := e.samples[0]
+	for _, s := range e.samples[1:] {
+		if s.delay < best.delay {
+			best = s
+		}
+	}
+
+	if len(e.history) > 0 && e.history[len(e.history)-1].at == best.at {
+		return false
+	}
+
+	e.history = append(e.history, best)
+	if len(e.history) > clockHistorySize {
+		e.history = e.history[1:]
+	}
+
+	e.offset, e.at = best.offset, best.at
+	e.drift = e.estimateDrift()
+
+	return true
+}
+
+// estimateDrift slope of filtered offsets by least squares, zero until they span long enough
+func (e *clockEstimate) estimateDrift() float64 {
+	first, last := e.history[0], e.history[len(e.history)-1]
+	if time.Duration(last.at-first.at) < minDriftSpan {
+		return 0
+	}
+
+	var meanAt, meanOffset float64
+	for _, s := range e.history {
+		meanAt += float64(s.at - first.at)
+		meanOffset += float64(s.offset)
+	}
+	meanAt /= float64(len(e.history))
+	meanOffset /= float64(len(e.history))
+
+	var covariance, variance float64
+	for _, s := range e.history {
+		dt := float64(s.at-first.at) - meanAt
+		covariance += dt * (float64(s.offset) - meanOffset)
+		variance += dt * dt
+	}
+
+	drift := covariance / variance
+	if drift > maxDrift {
+		drift = maxDrift
+	} else if drift < -maxDrift {
+		drift = -maxDrift
+	}
+
+	return drift
+}
+
+// Clock clock synchronization service. Measures offset and drift of peers clocks with NTP like exchanges
+type Clock struct {
+	message   chan proto.Message
+	Messenger *Messenger
+	log       *logrus.Entry
+	sb        *util.ServiceBag
+	peers     map[string]bool
+	pending   map[string]int64
+	estimates map[string]*clockEstimate
+}
+
+// Stop clean service when stopped by supervisor
+func (c *Clock) Stop() {
+	c.log.Info("Clock stopped.")
+}
+
+// Serve main service code
+func (c *Clock) Serve() {
+	c.log = util.GetContextLogger("service/clock.go", "Services/Clock")
+	c.log.Info("Clock starting...")
+
+	c.sb = util.GetServiceBag()
+	c.message = make(chan proto.Message)
+	c.peers = make(map[string]bool)
+	c.pending = make(map[string]int64)
+	c.estimates = make(map[string]*clockEstimate)
+
+	c.Messenger.RegisterSome([]byte{message.PeerOnlineMessage, message.PeerOfflineMessage, message.ClockRequestMessage, message.ClockResponseMessage}, c)
+
+	ticker := time.NewTicker(clockSyncInterval)
+	defer ticker.Stop()
+
+	c.log.Info("Clock started.")
+
+	for {
+		select {
+		case <-ticker.C:
+			c.sendRequests()
+		case packet := <-c.message:
+			msg, envelope := message.Unwrap(packet)
+			switch m := msg.(type) {
+			case *message.PeerOnline:
+				c.peers[m.Id] = true
+			case *message.PeerOffline:
+				delete(c.peers, m.Id)
+				delete(c.pending, m.Id)
+				delete(c.estimates, m.Id)
+			case *message.ClockRequest:
+				c.handleRequest(m, envelope)
+			case *message.ClockResponse:
+				c.handleResponse(m, envelope)
+			}
+		}
+	}
+}
+
+// GetChan returns messaging chan
+func (c *Clock) GetChan() chan proto.Message {
+	return c.message
+}
+
+func (c *Clock) sendRequests() {
+	for id := range c.peers {
+		originate := util.Now()
+		c.pending[id] = originate
+
+		data, _ := message.ToBuffer(&message.ClockRequest{Originate: originate})
+		c.send(&message.WriteRequest{DeviceName: id, Message: data})
+	}
+}
+
+// handleRequest answer with our reception and transmission times
+func (c *Clock) handleRequest(m *message.ClockRequest, envelope *message.Envelope) {
+	if envelope == nil {
+		return
+	}
+
+	receive := util.Now()
+	data, _ := message.ToBuffer(&message.ClockResponse{Originate: m.Originate, Receive: receive, Transmit: util.Now()})
+	c.send(&message.WriteRequest{DeviceName: envelope.Sender, Message: data})
+}
+
+// handleResponse measure peer clock offset from the answer to our last request
+func (c *Clock) handleResponse(m *message.ClockResponse, envelope *message.Envelope) {
+	now := util.Now()
+
+	// Only last request is answered, older answers would be too delayed anyway
+	if envelope == nil || c.pending[envelope.Sender] != m.Originate || m.Originate == 0 {
+		return
+	}
+	delete(c.pending, envelope.Sender)
+
+	delay := (now - m.Originate) - (m.Transmit - m.Receive)
+	if delay < 0 || time.Duration(delay) > maxClockDelay {
+		return
+	}
+
+	estimate, found := c.estimates[envelope.Sender]
+	if !found {
+		estimate = &clockEstimate{}
+		c.estimates[envelope.Sender] = estimate
+	}
+
+	offset := ((m.Receive - m.Originate) + (m.Transmit - now)) / 2
+	if !estimate.add(clockSample{at: now, offset: offset, delay: delay}) {
+		return
+	}
+
+	c.send(&message.ClockOffset{Device: envelope.Sender, Offset: estimate.offset, Drift: estimate.drift, Reference: estimate.at})
+}
+
+// send message to messenger without blocking it, it may be waiting for us to read next message
+func (c *Clock) send(msg proto.Message) {
+	go func() {
+		c.Messenger.Message <- msg
+	}()
+}
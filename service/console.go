@@ -0,0 +1,141 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/sirupsen/logrus"
+	"github.com/tuarrep/sounddrop/message"
+	"github.com/tuarrep/sounddrop/util"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Console line based control interface for the operator of device, e.g. to pair devices
+type Console struct {
+	message      chan proto.Message
+	Messenger    *Messenger
+	log          *logrus.Entry
+	sb           *util.ServiceBag
+	listener     net.Listener
+	clients      map[net.Conn]bool
+	clientsMutex sync.Mutex
+}
+
+// Stop clean service when stopped by supervisor
+func (c *Console) Stop() {
+	c.listener.Close()
+
+	c.clientsMutex.Lock()
+	for conn := range c.clients {
+		conn.Close()
+	}
+	c.clientsMutex.Unlock()
+
+	c.log.Info("Console stopped.")
+}
+
+// Serve main service code
+func (c *Console) Serve() {
+	c.log = util.GetContextLogger("service/console.go", "Services/Console")
+	c.log.Info("Console starting...")
+
+	c.sb = util.GetServiceBag()
+	c.message = make(chan proto.Message)
+	c.clients = make(map[net.Conn]bool)
+
+	var err error
+	c.listener, err = net.Listen("tcp", c.sb.Config.Mesh.Console)
+	util.CheckError(err, c.log)
+
+	if addr, ok := c.listener.Addr().(*net.TCPAddr); ok && !addr.IP.IsLoopback() {
+		c.log.Warn("Console is not authenticated and listens on ", addr, ", anyone reaching it can pair devices")
+	}
+
+	c.Messenger.Register(message.PairResultMessage, c)
+	go c.acceptLoop()
+
+	c.log.Info("Console started. Listening at ", c.listener.Addr().String())
+
+	for {
+		select {
+		case msg := <-c.message:
+			switch m := msg.(type) {
+			case *message.PairResult:
+				if m.Paired {
+					c.broadcast(fmt.Sprintf("Paired device %s", m.Device))
+				} else {
+					c.broadcast(fmt.Sprintf("Pairing with %s failed: %s", m.Device, m.Reason))
+				}
+			}
+		}
+	}
+}
+
+// GetChan returns messaging chan
+func (c *Console) GetChan() chan proto.Message {
+	return c.message
+}
+
+func (c *Console) acceptLoop() {
+	for {
+		conn, err := c.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c.clientsMutex.Lock()
+		c.clients[conn] = true
+		c.clientsMutex.Unlock()
+
+		go c.serveClient(conn)
+	}
+}
+
+// serveClient read commands of a client, one per line
+func (c *Console) serveClient(conn net.Conn) {
+	defer func() {
+		c.clientsMutex.Lock()
+		delete(c.clients, conn)
+		c.clientsMutex.Unlock()
+		conn.Close()
+	}()
+
+	fmt.Fprintf(conn, "Sounddrop %s. Type help for commands.\n", c.sb.DeviceID.String())
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "pair":
+			if len(fields) != 3 {
+				fmt.Fprintln(conn, "Usage: pair <device> <pin>")
+				continue
+			}
+			c.Messenger.Message <- &message.PairRequest{Device: fields[1], Pin: fields[2]}
+			fmt.Fprintf(conn, "Pairing with %s...\n", fields[1])
+		case "help":
+			fmt.Fprintln(conn, "pair <device> <pin>  accept a device (ID or ID prefix) showing this PIN")
+			fmt.Fprintln(conn, "quit                 close console")
+		case "quit":
+			return
+		default:
+			fmt.Fprintf(conn, "Unknown command %s. Type help for commands.\n", fields[0])
+		}
+	}
+}
+
+// broadcast write a line to every connected client
+func (c *Console) broadcast(line string) {
+	c.clientsMutex.Lock()
+	defer c.clientsMutex.Unlock()
+
+	for conn := range c.clients {
+		fmt.Fprintln(conn, line)
+	}
+}
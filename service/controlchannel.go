@@ -0,0 +1,354 @@
+package service
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/tuarrep/sounddrop/message"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+)
+
+// Delay before reconnecting to a peer after a control connection failure
+const controlRetryDelay = 1 * time.Second
+
+// Timeout of control connection establishment and writes, also used as keep alive period
+const controlTimeout = 5 * time.Second
+
+// Delay after which an idle inbound control connection is closed, senders connect again when they have messages
+const controlIdleTimeout = 60 * time.Second
+
+// Maximum number of inbound control connections served at the same time
+const maxControlConnections = 64
+
+// Maximum number of control messages waiting for acknowledgement of one peer
+const maxControlQueue = 256
+
+// Largest control message accepted
+const maxControlMessage = 1 << 20
+
+// Number of sending sessions for which last delivered sequence is remembered
+const maxControlSessions = 1024
+
+// Size of control frame header: message length, sender session and sequence
+const controlHeaderSize = 4 + 4 + 8
+
+// controlFrame control message waiting for acknowledgement
+type controlFrame struct {
+	sequence uint64
+	data     []byte
+}
+
+// controlPeer ordered queue of control messages sent to one peer and not acknowledged yet
+type controlPeer struct {
+	id           string
+	session      uint32
+	address      *net.TCPAddr
+	wrap         func(peer string, data []byte) ([]byte, error)
+	queue        []controlFrame
+	nextSequence uint64
+	mutex        sync.Mutex
+	wake         chan bool
+	done         chan bool
+}
+
+// controlChannel reliable and ordered delivery of mesh state and commands over TCP.
+// Every message is acknowledged by receiver and sent again on a new connection until it is
+type controlChannel struct {
+	listener       *net.TCPListener
+	connections    chan bool
+	peers          map[string]*controlPeer
+	peersMutex     sync.Mutex
+	delivered      map[uint32]uint64
+	deliveredMutex sync.Mutex
+	deliver        func(data []byte, addr *net.UDPAddr)
+	wrap           func(peer string, data []byte) ([]byte, error)
+	log            *logrus.Entry
+}
+
+// newControlChannel listen for control connections on TCP port. Received messages are passed to deliver in order.
+// When not nil, wrap transforms each message just before it is written, e.g. to encrypt it for peer
+func newControlChannel(port int, deliver func(data []byte, addr *net.UDPAddr), wrap func(peer string, data []byte) ([]byte, error), log *logrus.Entry) (*controlChannel, error) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: port})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &controlChannel{
+		listener:    listener,
+		connections: make(chan bool, maxControlConnections),
+		peers:       make(map[string]*controlPeer),
+		delivered:   make(map[uint32]uint64),
+		deliver:     deliver,
+		wrap:        wrap,
+		log:         log,
+	}
+	go c.acceptLoop()
+
+	return c, nil
+}
+
+// send queue a message for a peer reachable at address, it is delivered once even if connection breaks
+func (c *controlChannel) send(id string, address *net.UDPAddr, data []byte) {
+	c.peersMutex.Lock()
+	peer, found := c.peers[id]
+	if !found {
+		peer = &controlPeer{id: id, session: newStreamID(), nextSequence: 1, wrap: c.wrap, wake: make(chan bool, 1), done: make(chan bool)}
+		c.peers[id] = peer
+		go peer.run(c.log)
+	}
+	c.peersMutex.Unlock()
+
+	peer.mutex.Lock()
+	peer.address = &net.TCPAddr{IP: address.IP, Port: address.Port, Zone: address.Zone}
+	if len(peer.queue) >= maxControlQueue {
+		c.log.Warn(fmt.Sprintf("Control queue of %s is full, dropping oldest message", id))
+		peer.queue = peer.queue[1:]
+	}
+	peer.queue = append(peer.queue, controlFrame{sequence: peer.nextSequence, data: data})
+	peer.nextSequence++
+	peer.mutex.Unlock()
+
+	peer.notify()
+}
+
+// forget stop sending to a peer gone offline, dropping messages still queued for it
+func (c *controlChannel) forget(id string) {
+	c.peersMutex.Lock()
+	defer c.peersMutex.Unlock()
+
+	if peer, found := c.peers[id]; found {
+		close(peer.done)
+		delete(c.peers, id)
+	}
+}
+
+// stop close listener and stop sending to peers
+func (c *controlChannel) stop() {
+	c.listener.Close()
+
+	c.peersMutex.Lock()
+	for id, peer := range c.peers {
+		close(peer.done)
+		delete(c.peers, id)
+	}
+	c.peersMutex.Unlock()
+}
+
+func (c *controlChannel) acceptLoop() {
+	for {
+		conn, err := c.listener.AcceptTCP()
+		if err != nil {
+			return
+		}
+
+		select {
+		case c.connections <- true:
+		default:
+			c.log.Debug(fmt.Sprintf("Too many control connections, refusing one from %s", conn.RemoteAddr()))
+			conn.Close()
+			continue
+		}
+
+		go func() {
+			c.receive(conn)
+			<-c.connections
+		}()
+	}
+}
+
+// receive read control frames from a connection, deliver new ones and acknowledge all of them
+func (c *controlChannel) receive(conn *net.TCPConn) {
+	defer conn.Close()
+
+	remote := conn.RemoteAddr().(*net.TCPAddr)
+	addr := &net.UDPAddr{IP: remote.IP, Port: remote.Port, Zone: remote.Zone}
+	header := make([]byte, controlHeaderSize)
+	ack := make([]byte, 8)
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(controlIdleTimeout))
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+
+		length := binary.BigEndian.Uint32(header[0:4])
+		session := binary.BigEndian.Uint32(header[4:8])
+		sequence := binary.BigEndian.Uint64(header[8:16])
+
+		if length > maxControlMessage {
+			c.log.Warn(fmt.Sprintf("Control message of %d bytes from %s is too large, closing connection", length, addr))
+			return
+		}
+
+		// Buffer grows as data arrives, announced length alone does not allocate memory
+		_ = conn.SetReadDeadline(time.Now().Add(controlTimeout))
+		data, err := ioutil.ReadAll(io.LimitReader(conn, int64(length)))
+		if err != nil || len(data) < int(length) {
+			return
+		}
+
+		if c.isNew(session, sequence) {
+			c.deliver(data, addr)
+		}
+
+		binary.BigEndian.PutUint64(ack, sequence)
+		_ = conn.SetWriteDeadline(time.Now().Add(controlTimeout))
+		if _, err := conn.Write(ack); err != nil {
+			return
+		}
+	}
+}
+
+// isNew record a received sequence and tell whether it was not delivered yet
+func (c *controlChannel) isNew(session uint32, sequence uint64) bool {
+	c.deliveredMutex.Lock()
+	defer c.deliveredMutex.Unlock()
+
+	last, found := c.delivered[session]
+	if found && sequence <= last {
+		return false
+	}
+
+	if !found && len(c.delivered) >= maxControlSessions {
+		c.delivered = make(map[uint32]uint64)
+	}
+	c.delivered[session] = sequence
+
+	return true
+}
+
+// notify wake up peer sending loop without blocking
+func (p *controlPeer) notify() {
+	select {
+	case p.wake <- true:
+	default:
+	}
+}
+
+// run connect to peer whenever messages are waiting and send them until they are acknowledged
+func (p *controlPeer) run(log *logrus.Entry) {
+	for {
+		if p.pending() == 0 {
+			select {
+			case <-p.wake:
+				continue
+			case <-p.done:
+				return
+			}
+		}
+
+		p.mutex.Lock()
+		address := p.address.String()
+		p.mutex.Unlock()
+
+		conn, err := net.DialTimeout("tcp", address, controlTimeout)
+		if err == nil {
+			err = p.serve(conn.(*net.TCPConn))
+			conn.Close()
+		}
+
+		if err != nil {
+			log.Debug(fmt.Sprintf("Control connection to %s failed (%v), retrying", p.id, err))
+		}
+
+		select {
+		case <-time.After(controlRetryDelay):
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// serve send queued messages in order on one connection, handling acknowledgements, until connection fails
+func (p *controlPeer) serve(conn *net.TCPConn) error {
+	_ = conn.SetKeepAlive(true)
+	_ = conn.SetKeepAlivePeriod(controlTimeout)
+
+	failed := make(chan error, 1)
+	go func() {
+		ack := make([]byte, 8)
+		for {
+			if _, err := io.ReadFull(conn, ack); err != nil {
+				failed <- err
+				return
+			}
+			p.acknowledge(binary.BigEndian.Uint64(ack))
+		}
+	}()
+
+	var written uint64
+	for {
+		for _, frame := range p.unsent(written) {
+			// Message may be sent again long after being built, receiver would take it for a replay
+			payload, err := message.Restamp(frame.data)
+			if err != nil {
+				payload = frame.data
+			}
+
+			if p.wrap != nil {
+				// Fails while no session keys are negotiated yet, message is sent on next connection
+				if payload, err = p.wrap(p.id, payload); err != nil {
+					return err
+				}
+			}
+
+			data := make([]byte, controlHeaderSize+len(payload))
+			binary.BigEndian.PutUint32(data[0:4], uint32(len(payload)))
+			binary.BigEndian.PutUint32(data[4:8], p.session)
+			binary.BigEndian.PutUint64(data[8:16], frame.sequence)
+			copy(data[controlHeaderSize:], payload)
+
+			_ = conn.SetWriteDeadline(time.Now().Add(controlTimeout))
+			if _, err := conn.Write(data); err != nil {
+				return err
+			}
+			written = frame.sequence
+		}
+
+		select {
+		case <-p.wake:
+		case err := <-failed:
+			if err == io.EOF && p.pending() == 0 {
+				return nil
+			}
+			return err
+		case <-p.done:
+			return nil
+		}
+	}
+}
+
+func (p *controlPeer) pending() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return len(p.queue)
+}
+
+// unsent queued messages with a sequence after the last one written on current connection
+func (p *controlPeer) unsent(written uint64) []controlFrame {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var frames []controlFrame
+	for _, frame := range p.queue {
+		if frame.sequence > written {
+			frames = append(frames, frame)
+		}
+	}
+
+	return frames
+}
+
+// acknowledge remove messages up to sequence from queue
+func (p *controlPeer) acknowledge(sequence uint64) {
+	p.mutex.Lock()
+	for len(p.queue) > 0 && p.queue[0].sequence <= sequence {
+		p.queue = p.queue[1:]
+	}
+	p.mutex.Unlock()
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/grandcat/zeroconf"
+	"github.com/sirupsen/logrus"
+	"github.com/tuarrep/sounddrop/message"
+	"github.com/tuarrep/sounddrop/util"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DNS-SD service type of Sounddrop devices
+const dnssdService = "_sounddrop._udp"
+
+// DNS-SD domain used on local network
+const dnssdDomain = "local."
+
+// Delay between two browsing rounds
+const dnssdBrowseInterval = 10 * time.Second
+
+// Duration of one browsing round
+const dnssdBrowseWindow = 3 * time.Second
+
+// Delay after which a device not found again by browsing is considered offline
+const dnssdPeerTimeout = 3 * dnssdBrowseInterval
+
+// DNSSD publish device as a DNS-SD service and browse for other devices
+type DNSSD struct {
+	Messenger *Messenger
+	log       *logrus.Entry
+	sb        *util.ServiceBag
+	server    *zeroconf.Server
+	stop      chan bool
+}
+
+// Stop clean service when stopped by supervisor
+func (d *DNSSD) Stop() {
+	if d.server != nil {
+		d.server.Shutdown()
+	}
+	close(d.stop)
+	d.log.Info("DNS-SD stopped.")
+}
+
+// Serve main service code
+func (d *DNSSD) Serve() {
+	d.log = util.GetContextLogger("service/dnssd.go", "Services/DNSSD")
+	d.log.Info("DNS-SD starting...")
+
+	d.sb = util.GetServiceBag()
+	d.stop = make(chan bool)
+
+	interfaces := d.selectInterfaces()
+
+	var err error
+	d.server, err = zeroconf.Register(d.instanceName(), dnssdService, dnssdDomain, d.sb.Config.Discover.Port, d.text(), interfaces)
+	util.CheckError(err, d.log)
+
+	d.log.Info(fmt.Sprintf("DNS-SD started. Published as %s.%s%s", d.instanceName(), dnssdService, dnssdDomain))
+
+	for {
+		d.browse(interfaces)
+
+		select {
+		case <-time.After(dnssdBrowseInterval):
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// browse look for other devices during one browsing round, reporting each one found to server
+func (d *DNSSD) browse(interfaces []net.Interface) {
+	// Resolver closes its connections once browsing ends, a new one is needed for each round
+	resolver, err := zeroconf.NewResolver(zeroconf.SelectIfaces(interfaces))
+	if err != nil {
+		d.log.Warn("Unable to create DNS-SD resolver: ", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnssdBrowseWindow)
+	defer cancel()
+
+	entries := make(chan *zeroconf.ServiceEntry)
+	go func() {
+		for entry := range entries {
+			d.handleEntry(entry)
+		}
+	}()
+
+	if err := resolver.Browse(ctx, dnssdService, dnssdDomain, entries); err != nil {
+		d.log.Warn("Unable to browse DNS-SD services: ", err)
+		return
+	}
+
+	<-ctx.Done()
+}
+
+func (d *DNSSD) handleEntry(entry *zeroconf.ServiceEntry) {
+	txt := parseText(entry.Text)
+
+	id := txt["id"]
+	if id == "" || id == d.sb.DeviceID.String() || txt["mesh"] != d.sb.Config.Mesh.ID {
+		return
+	}
+
+	var ip net.IP
+	if len(entry.AddrIPv4) > 0 {
+		ip = entry.AddrIPv4[0]
+	} else if len(entry.AddrIPv6) > 0 {
+		ip = entry.AddrIPv6[0]
+	} else {
+		return
+	}
+
+	port := entry.Port
+	if txtPort, err := strconv.Atoi(txt["port"]); err == nil {
+		port = txtPort
+	}
+
+	d.Messenger.Message <- &message.PeerDiscovered{Id: id, Address: net.JoinHostPort(ip.String(), strconv.Itoa(port)), MulticastGroup: txt["multicast"]}
+}
+
+// selectInterfaces interfaces DNS-SD is published and browsed on, nil for all
+func (d *DNSSD) selectInterfaces() []net.Interface {
+	if len(d.sb.Config.Discover.Interfaces) == 0 && len(d.sb.Config.Discover.ExcludeInterfaces) == 0 {
+		return nil
+	}
+
+	interfaces, err := net.Interfaces()
+	util.CheckError(err, d.log)
+
+	var selected []net.Interface
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 && isSelectedInterface(d.sb.Config.Discover, iface) {
+			selected = append(selected, iface)
+		}
+	}
+
+	return selected
+}
+
+// instanceName unique and readable DNS-SD instance name
+func (d *DNSSD) instanceName() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "sounddrop"
+	}
+
+	return fmt.Sprintf("%s-%s", hostname, d.sb.DeviceID.String()[:8])
+}
+
+// text TXT records describing device
+func (d *DNSSD) text() []string {
+	hostname, _ := os.Hostname()
+
+	text := []string{
+		"id=" + d.sb.DeviceID.String(),
+		"name=" + hostname,
+		"port=" + strconv.Itoa(d.sb.Config.Discover.Port),
+		"version=" + strconv.Itoa(int(message.ProtocolVersion)),
+		"codecs=pcm,opus,flac",
+		"player=1",
+	}
+
+	if d.sb.Config.Streamer.AutoStart {
+		text = append(text, "streamer=1")
+	}
+	if d.sb.Config.Mesh.ID != "" {
+		text = append(text, "mesh="+d.sb.Config.Mesh.ID)
+	}
+	if d.sb.Config.Discover.MulticastGroup != "" {
+		text = append(text, "multicast="+d.sb.Config.Discover.MulticastGroup)
+	}
+
+	return text
+}
+
+// parseText TXT records as key value map
+func parseText(text []string) map[string]string {
+	values := make(map[string]string)
+	for _, record := range text {
+		parts := strings.SplitN(record, "=", 2)
+		if len(parts) == 2 {
+			values[parts[0]] = parts[1]
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"fmt"
+	"github.com/faiface/beep"
+	"github.com/sirupsen/logrus"
+	"github.com/tuarrep/sounddrop/util"
+	"math"
+	"time"
+)
+
+// Shortest measurement span sound card drift is estimated over, consumption is too jittery on shorter spans
+const minDriftMeasure = 10 * time.Second
+
+// Longest pause between two speaker requests before measurement starts again, sound card was stopped
+const maxSpeakerPause = 1 * time.Second
+
+// Delay between two resampling ratio updates
+const ratioUpdateInterval = 100 * time.Millisecond
+
+// Largest resampling correction of timing errors, pitch changes by less than 2 cents which is inaudible
+const maxRatioCorrection = 1e-3
+
+// Resampling correction per second of timing error
+const timingGain = 0.1
+
+// Weight of last timing error in its moving average
+const timingSmoothing = 0.01
+
+// driftCompensator estimate sound card clock drift by comparing samples it consumes with elapsed time, and compensate it
+// by resampling played audio. Remaining timing errors are slowly corrected the same way instead of dropping samples or
+// inserting silence. Only used from speaker goroutine
+type driftCompensator struct {
+	resampler   *beep.Resampler
+	sampleRate  beep.SampleRate
+	log         *logrus.Entry
+	startedAt   int64
+	requestedAt int64
+	updatedAt   int64
+	consumed    int64
+	drift       float64
+	timingError float64
+}
+
+func newDriftCompensator(source beep.Streamer, sampleRate beep.SampleRate, quality int, log *logrus.Entry) *driftCompensator {
+	return &driftCompensator{resampler: beep.ResampleRatio(quality, 1, source), sampleRate: sampleRate, log: log}
+}
+
+// Stream stream resampled audio, counting samples consumed by sound card
+func (d *driftCompensator) Stream(samples [][2]float64) (n int, ok bool) {
+	now := util.Now()
+	if d.startedAt == 0 || time.Duration(now-d.requestedAt) > maxSpeakerPause {
+		d.startedAt, d.consumed = now, 0
+	}
+	d.requestedAt = now
+
+	if time.Duration(now-d.updatedAt) >= ratioUpdateInterval {
+		d.update(now)
+	}
+
+	n, ok = d.resampler.Stream(samples)
+	d.consumed += int64(n)
+
+	return n, ok
+}
+
+// Err return streaming error
+func (d *driftCompensator) Err() error {
+	return d.resampler.Err()
+}
+
+// observe record how early next sample is played compared to its scheduled time
+func (d *driftCompensator) observe(early time.Duration) {
+	d.timingError += timingSmoothing * (early.Seconds() - d.timingError)
+}
+
+// update estimate drift from samples consumed before now and set resampling ratio accordingly
+func (d *driftCompensator) update(now int64) {
+	d.updatedAt = now
+
+	elapsed := time.Duration(now - d.startedAt)
+	if elapsed >= minDriftMeasure {
+		drift := clamp(float64(d.consumed)/(elapsed.Seconds()*float64(d.sampleRate))-1, maxDrift)
+		if math.Abs(drift-d.drift) > 10e-6 {
+			d.log.Debug(fmt.Sprintf("Sound card drift is %.1f ppm", drift*1e6))
+		}
+		d.drift = drift
+	}
+
+	// Sound card consuming faster than expected plays input slower, early audio is played slower too
+	correction := clamp(-timingGain*d.timingError, maxRatioCorrection)
+	d.resampler.SetRatio(1/(1+d.drift) + correction)
+}
+
+// clamp limit a value to [-limit, limit]
+func clamp(value float64, limit float64) float64 {
+	return math.Max(-limit, math.Min(limit, value))
+}
@@ -0,0 +1,431 @@
+package service
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/flynn/noise"
+	"github.com/tuarrep/sounddrop/message"
+	"github.com/tuarrep/sounddrop/util"
+	"net"
+	"sync"
+	"time"
+)
+
+// Delay after which session keys are negotiated again
+const keyRotationInterval = 10 * time.Minute
+
+// Delay during which previous session keys are still accepted after a rotation
+const keyRotationGrace = 30 * time.Second
+
+// Delay after which an unanswered handshake is started again
+const handshakeTimeout = 5 * time.Second
+
+// Number of packets sent with one session keys before they are negotiated again
+const maxSessionPackets = 1 << 32
+
+// Size of AEAD authentication tag
+const sealTagSize = 16
+
+// cryptoSession keys negotiated with a peer
+type cryptoSession struct {
+	id        uint32
+	peer      string
+	send      noise.Cipher
+	receive   noise.Cipher
+	counter   uint64
+	createdAt time.Time
+	expiresAt time.Time
+}
+
+// sessionKey sessions are identified by their peer and the identifier its initiator chose
+type sessionKey struct {
+	peer string
+	id   uint32
+}
+
+// peerCrypto encryption state of one peer: current session, session negotiated by peer but not confirmed yet
+// and handshake in progress
+type peerCrypto struct {
+	current     *cryptoSession
+	next        *cryptoSession
+	handshake   *noise.HandshakeState
+	handshakeID uint32
+	handshakeAt time.Time
+	requestedAt time.Time
+}
+
+// errUnknownSession message sealed with keys we do not have, e.g. negotiated before we restarted
+var errUnknownSession = fmt.Errorf("unknown encryption session")
+
+// encryption per peer AEAD sessions, negotiated with a Noise NNpsk0 handshake keyed by mesh secret
+type encryption struct {
+	psk      []byte
+	prologue []byte
+	deviceID string
+	peers    map[string]*peerCrypto
+	sessions map[sessionKey]*cryptoSession
+	mutex    sync.Mutex
+}
+
+func newEncryption(secret string, meshID string, deviceID string) *encryption {
+	psk := sha256.Sum256([]byte("sounddrop noise psk " + secret))
+
+	return &encryption{
+		psk:      psk[:],
+		prologue: []byte("sounddrop/" + meshID),
+		deviceID: deviceID,
+		peers:    make(map[string]*peerCrypto),
+		sessions: make(map[sessionKey]*cryptoSession),
+	}
+}
+
+func (e *encryption) config(initiator bool) noise.Config {
+	return noise.Config{
+		CipherSuite:           noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashSHA256),
+		Pattern:               noise.HandshakeNN,
+		Initiator:             initiator,
+		Prologue:              e.prologue,
+		PresharedKey:          e.psk,
+		PresharedKeyPlacement: 0,
+	}
+}
+
+func (e *encryption) peer(id string) *peerCrypto {
+	pc, found := e.peers[id]
+	if !found {
+		pc = &peerCrypto{}
+		e.peers[id] = pc
+	}
+
+	return pc
+}
+
+// seal encrypt a message for a peer with current session keys
+func (e *encryption) seal(peer string, data []byte) ([]byte, error) {
+	e.mutex.Lock()
+	pc, found := e.peers[peer]
+	if !found || pc.current == nil {
+		e.mutex.Unlock()
+		return nil, fmt.Errorf("no encryption session with %s yet", peer)
+	}
+
+	session := pc.current
+	counter := session.counter
+	session.counter++
+	e.mutex.Unlock()
+
+	// Explicit counter as nonce, packets can be lost or reordered
+	ciphertext := session.send.Encrypt(nil, counter, nil, data)
+
+	return message.ToBuffer(&message.Sealed{Session: session.id, Counter: counter, Ciphertext: ciphertext})
+}
+
+// open decrypt a message sealed by sender
+func (e *encryption) open(sealed *message.Sealed, sender string) ([]byte, error) {
+	e.mutex.Lock()
+	session, found := e.sessions[sessionKey{peer: sender, id: sealed.Session}]
+	e.mutex.Unlock()
+
+	if !found {
+		return nil, errUnknownSession
+	}
+
+	if !session.expiresAt.IsZero() && time.Now().After(session.expiresAt) {
+		return nil, fmt.Errorf("expired encryption session %08x", sealed.Session)
+	}
+
+	plaintext, err := session.receive.Decrypt(nil, sealed.Counter, nil, sealed.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	// Initiator uses keys once it has them, we can use them too
+	e.mutex.Lock()
+	if pc, found := e.peers[sender]; found && pc.next == session {
+		e.promote(pc, session)
+		pc.next = nil
+	}
+	e.mutex.Unlock()
+
+	return plaintext, nil
+}
+
+// initiates whether device starts handshakes with peer. Lowest device ID does, so both never start at once
+func (e *encryption) initiates(peer string) bool {
+	return e.deviceID < peer
+}
+
+// maintain start handshakes with peers lacking a session or due for key rotation. Returns handshake messages by peer
+func (e *encryption) maintain(peers []string) map[string][]byte {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.sweep()
+
+	handshakes := make(map[string][]byte)
+	for _, peer := range peers {
+		if !e.initiates(peer) {
+			continue
+		}
+
+		pc := e.peer(peer)
+		current := pc.current
+		if current != nil && time.Since(current.createdAt) < keyRotationInterval && current.counter < maxSessionPackets {
+			continue
+		}
+
+		if pc.handshake != nil && time.Since(pc.handshakeAt) < handshakeTimeout {
+			continue
+		}
+
+		data, err := e.startHandshake(peer, pc)
+		if err == nil {
+			handshakes[peer] = data
+		}
+	}
+
+	return handshakes
+}
+
+// restart negotiate keys again with a peer sending messages sealed with unknown keys, it probably restarted.
+// Returns a new handshake when we initiate, a request to start one otherwise, nil when one was sent recently
+func (e *encryption) restart(peer string) ([]byte, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	pc := e.peer(peer)
+	if e.initiates(peer) {
+		return e.restartHandshake(peer, pc)
+	}
+
+	if time.Since(pc.requestedAt) < handshakeTimeout {
+		return nil, nil
+	}
+	pc.requestedAt = time.Now()
+
+	return message.ToBuffer(&message.Handshake{Stage: 0})
+}
+
+// restartHandshake start a handshake now unless one is already in progress
+func (e *encryption) restartHandshake(peer string, pc *peerCrypto) ([]byte, error) {
+	if pc.handshake != nil && time.Since(pc.handshakeAt) < handshakeTimeout {
+		return nil, nil
+	}
+
+	return e.startHandshake(peer, pc)
+}
+
+func (e *encryption) startHandshake(peer string, pc *peerCrypto) ([]byte, error) {
+	hs, err := noise.NewHandshakeState(e.config(true))
+	if err != nil {
+		return nil, err
+	}
+
+	payload, _, _, err := hs.WriteMessage(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	id := newStreamID()
+	for e.sessions[sessionKey{peer: peer, id: id}] != nil {
+		id = newStreamID()
+	}
+
+	pc.handshake, pc.handshakeID, pc.handshakeAt = hs, id, time.Now()
+
+	return message.ToBuffer(&message.Handshake{Session: pc.handshakeID, Stage: 1, Payload: payload})
+}
+
+// handleHandshake process a handshake message of a peer. Returns the answer to send back, if any
+func (e *encryption) handleHandshake(peer string, m *message.Handshake) ([]byte, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	switch m.Stage {
+	case 0:
+		// Peer lost the keys we negotiated, e.g. it restarted
+		if !e.initiates(peer) {
+			return nil, fmt.Errorf("unexpected handshake request from %s", peer)
+		}
+
+		return e.restartHandshake(peer, e.peer(peer))
+	case 1:
+		if _, found := e.sessions[sessionKey{peer: peer, id: m.Session}]; found {
+			return nil, fmt.Errorf("handshake from %s reuses session %08x", peer, m.Session)
+		}
+
+		hs, err := noise.NewHandshakeState(e.config(false))
+		if err != nil {
+			return nil, err
+		}
+
+		// Fails when peer does not share our mesh secret
+		if _, _, _, err := hs.ReadMessage(nil, m.Payload); err != nil {
+			return nil, fmt.Errorf("handshake from %s failed: %v", peer, err)
+		}
+
+		payload, initiatorToResponder, responderToInitiator, err := hs.WriteMessage(nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// Initiator does not have the keys before it reads our answer, previous ones are used until it confirms them
+		pc := e.peer(peer)
+		if pc.next != nil {
+			delete(e.sessions, sessionKey{peer: peer, id: pc.next.id})
+		}
+		pc.next = e.newSession(peer, m.Session, responderToInitiator.Cipher(), initiatorToResponder.Cipher())
+
+		return message.ToBuffer(&message.Handshake{Session: m.Session, Stage: 2, Payload: payload})
+	case 2:
+		pc := e.peer(peer)
+		if pc.handshake == nil || pc.handshakeID != m.Session {
+			return nil, fmt.Errorf("unexpected handshake answer from %s", peer)
+		}
+
+		_, initiatorToResponder, responderToInitiator, err := pc.handshake.ReadMessage(nil, m.Payload)
+		pc.handshake = nil
+		if err != nil {
+			return nil, fmt.Errorf("handshake with %s failed: %v", peer, err)
+		}
+
+		session := e.newSession(peer, m.Session, initiatorToResponder.Cipher(), responderToInitiator.Cipher())
+		e.promote(pc, session)
+
+		// Any packet sealed with new keys confirms them, send one at once
+		confirmation, err := message.ToBuffer(&message.Handshake{Session: m.Session, Stage: 3})
+		if err != nil {
+			return nil, err
+		}
+
+		counter := session.counter
+		session.counter++
+		return message.ToBuffer(&message.Sealed{Session: session.id, Counter: counter, Ciphertext: session.send.Encrypt(nil, counter, nil, confirmation)})
+	case 3:
+		// Sealed confirmation made new keys current when it was opened
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("invalid handshake stage %d", m.Stage)
+	}
+}
+
+// newSession register keys negotiated with a peer, so messages sealed with them can be opened
+func (e *encryption) newSession(peer string, id uint32, send noise.Cipher, receive noise.Cipher) *cryptoSession {
+	session := &cryptoSession{id: id, peer: peer, send: send, receive: receive, createdAt: time.Now()}
+	e.sessions[sessionKey{peer: peer, id: id}] = session
+
+	return session
+}
+
+// promote make session keys current to send, previous ones are still accepted for a while
+func (e *encryption) promote(pc *peerCrypto, session *cryptoSession) {
+	if pc.current != nil {
+		pc.current.expiresAt = time.Now().Add(keyRotationGrace)
+	}
+
+	pc.current = session
+}
+
+// forget drop keys of a peer gone offline
+func (e *encryption) forget(peer string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	delete(e.peers, peer)
+	for key := range e.sessions {
+		if key.peer == peer {
+			delete(e.sessions, key)
+		}
+	}
+}
+
+// sweep drop sessions no longer accepted
+func (e *encryption) sweep() {
+	for key, session := range e.sessions {
+		if !session.expiresAt.IsZero() && time.Now().After(session.expiresAt) {
+			delete(e.sessions, key)
+		}
+	}
+}
+
+// startEncryption set up per peer encryption when enabled, it relies on mesh key to authenticate handshakes
+func (srv *Server) startEncryption() {
+	if !srv.sb.Config.Mesh.Encrypt {
+		return
+	}
+
+	if srv.sb.Config.Mesh.Key == "" {
+		util.CheckError(fmt.Errorf("encryption needs a mesh key"), srv.log)
+	}
+
+	srv.encryption = newEncryption(srv.sb.Config.Mesh.Key, srv.sb.Config.Mesh.ID, srv.sb.DeviceID.String())
+	srv.log.Info("Audio and mesh messages are encrypted, multicast is disabled")
+}
+
+// maintainEncryption send handshakes to accepted peers, or being paired, needing new session keys. Until it is accepted
+// itself, device negotiates keys with every identified peer so it can be paired
+func (srv *Server) maintainEncryption() {
+	if srv.encryption == nil {
+		return
+	}
+
+	ids := make([]string, 0, len(srv.peers))
+	for id, peer := range srv.peers {
+		if peer.publicKey == nil || (srv.selfAccepted && !srv.trusted[id]) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	for id, data := range srv.encryption.maintain(ids) {
+		if err := srv.writeTo(data, srv.peers[id].destination()); err != nil {
+			srv.log.Debug(fmt.Sprintf("Unable to send handshake to %s: %v", id, err))
+		}
+	}
+}
+
+// restartEncryption negotiate keys again with a known peer sending messages we cannot decrypt, instead of
+// waiting for next key rotation
+func (srv *Server) restartEncryption(sender string) {
+	peer, known := srv.peers[sender]
+	if !known {
+		return
+	}
+
+	data, err := srv.encryption.restart(sender)
+	if err != nil || data == nil {
+		return
+	}
+
+	// Message may have come through control channel, from an address handshakes cannot be sent to
+	srv.log.Debug("Negotiating encryption keys again with ", sender)
+	if err := srv.writeTo(data, peer.destination()); err != nil {
+		srv.log.Debug(fmt.Sprintf("Unable to send handshake to %s: %v", sender, err))
+	}
+}
+
+// handleHandshake process a handshake message and send back the answer, if any
+func (srv *Server) handleHandshake(m *message.Handshake, sender string, addr *net.UDPAddr) {
+	if srv.encryption == nil {
+		return
+	}
+
+	answer, err := srv.encryption.handleHandshake(sender, m)
+	if err != nil {
+		srv.reject(addr, err)
+		return
+	}
+
+	// Initiator uses keys once it reads answer, responder once initiator confirms them
+	if m.Stage >= 2 {
+		srv.log.Debug(fmt.Sprintf("Encryption session %08x established with %s", m.Session, sender))
+	}
+
+	if answer == nil {
+		return
+	}
+
+	if err := srv.writeTo(answer, destination{address: addr}); err != nil {
+		srv.log.Debug(fmt.Sprintf("Unable to answer handshake of %s: %v", sender, err))
+	}
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"bytes"
+	"github.com/tuarrep/sounddrop/message"
+	"testing"
+)
+
+// handshake decode a handshake built by encryption
+func handshake(t *testing.T, data []byte) *message.Handshake {
+	packet, err := message.FromBuffer(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return packet.Message.(*message.Handshake)
+}
+
+// exchange seal data for a peer and open it there
+func exchange(from *encryption, to *encryption, data []byte) ([]byte, error) {
+	sealed, err := from.seal(to.deviceID, data)
+	if err != nil {
+		return nil, err
+	}
+
+	packet, err := message.FromBuffer(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	return to.open(packet.Message.(*message.Sealed), from.deviceID)
+}
+
+// negotiate run a handshake started by initiator, returning confirmation responder did not receive yet
+func negotiate(t *testing.T, initiator *encryption, responder *encryption) *message.Sealed {
+	start := initiator.maintain([]string{responder.deviceID})[responder.deviceID]
+	if start == nil {
+		t.Fatal("no handshake started")
+	}
+
+	answer, err := responder.handleHandshake(initiator.deviceID, handshake(t, start))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	confirmation, err := initiator.handleHandshake(responder.deviceID, handshake(t, answer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet, err := message.FromBuffer(confirmation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return packet.Message.(*message.Sealed)
+}
+
+func TestEncryptionResponderWaitsForConfirmation(t *testing.T) {
+	a := newEncryption("secret", "", "a")
+	b := newEncryption("secret", "", "b")
+
+	confirmation := negotiate(t, a, b)
+
+	if _, err := b.seal("a", []byte("early")); err == nil {
+		t.Error("responder sealed with keys initiator may not have yet")
+	}
+
+	plaintext, err := b.open(confirmation, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handshake(t, plaintext).Stage != 3 {
+		t.Errorf("confirmation holds %v", plaintext)
+	}
+
+	for _, pair := range [][2]*encryption{{a, b}, {b, a}} {
+		data, err := exchange(pair[0], pair[1], []byte("audio"))
+		if err != nil || !bytes.Equal(data, []byte("audio")) {
+			t.Errorf("%s to %s: %q (%v)", pair[0].deviceID, pair[1].deviceID, data, err)
+		}
+	}
+}
+
+func TestEncryptionRotation(t *testing.T) {
+	a := newEncryption("secret", "", "a")
+	b := newEncryption("secret", "", "b")
+
+	b.open(negotiate(t, a, b), "a")
+	first := a.peers["b"].current.id
+
+	// Rotation is due, responder keeps previous keys until it hears from initiator with new ones
+	a.peers["b"].current.counter = maxSessionPackets
+	confirmation := negotiate(t, a, b)
+
+	if b.peers["a"].current.id != first {
+		t.Error("responder switched keys before confirmation")
+	}
+	if _, err := exchange(b, a, []byte("status")); err != nil {
+		t.Errorf("initiator cannot open previous keys during rotation: %v", err)
+	}
+
+	if _, err := exchange(a, b, []byte("audio")); err != nil {
+		t.Fatal(err)
+	}
+	if b.peers["a"].current.id == first {
+		t.Error("responder kept previous keys after receiving new ones")
+	}
+	if _, err := b.open(confirmation, "a"); err != nil {
+		t.Errorf("late confirmation: %v", err)
+	}
+}
+
+func TestEncryptionWrongSecret(t *testing.T) {
+	a := newEncryption("secret", "", "a")
+	b := newEncryption("other", "", "b")
+
+	start := a.maintain([]string{"b"})["b"]
+	if _, err := b.handleHandshake("a", handshake(t, start)); err == nil {
+		t.Error("handshake with another mesh secret succeeded")
+	}
+}
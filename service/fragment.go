@@ -0,0 +1,143 @@
+package service
+
+import (
+	"fmt"
+	"github.com/tuarrep/sounddrop/message"
+	"math"
+	"time"
+)
+
+// Room left in each datagram for the lengths of fragment data and of envelope payload, which an empty fragment does not have,
+// and for sequence and time fields growing between fragments
+const fragmentLengthRoom = 16
+
+// Maximum number of fragments of one message
+const maxFragments = 64
+
+// Maximum number of messages being reassembled at the same time
+const maxReassemblies = 256
+
+// Delay after which an incomplete message is dropped
+const reassemblyTimeout = 2 * time.Second
+
+// fragment split a message too large for one datagram into fragment messages
+func (srv *Server) fragment(data []byte) ([][]byte, error) {
+	overhead, err := fragmentOverhead()
+	if err != nil {
+		return nil, err
+	}
+
+	chunkSize := srv.sb.Config.Discover.MTU - overhead
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("MTU %d is too small", srv.sb.Config.Discover.MTU)
+	}
+
+	count := (len(data) + chunkSize - 1) / chunkSize
+	if count > maxFragments {
+		return nil, fmt.Errorf("message of %d bytes needs %d fragments, limit is %d", len(data), count, maxFragments)
+	}
+
+	srv.fragmentID++
+	fragments := make([][]byte, 0, count)
+	for index := 0; index < count; index++ {
+		end := (index + 1) * chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		fragment := &message.Fragment{Id: srv.fragmentID, Index: uint32(index), Count: uint32(count), Data: data[index*chunkSize : end]}
+		fragmentData, err := message.ToBuffer(fragment)
+		if err != nil {
+			return nil, err
+		}
+		fragments = append(fragments, fragmentData)
+	}
+
+	return fragments, nil
+}
+
+// fragmentOverhead size of a datagram holding a fragment besides its data: envelope, mesh ID and authentication code included
+func fragmentOverhead() (int, error) {
+	empty, err := message.ToBuffer(&message.Fragment{Id: math.MaxUint32, Index: maxFragments - 1, Count: maxFragments})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(empty) + fragmentLengthRoom, nil
+}
+
+// reassembly fragments received so far for one message
+type reassembly struct {
+	fragments [][]byte
+	received  int
+	size      int
+	startedAt time.Time
+}
+
+// reassembler rebuild messages from their fragments
+type reassembler struct {
+	pending   map[string]*reassembly
+	lastSweep time.Time
+}
+
+// add a fragment received from source. Returns the whole message once every fragment has been received
+func (r *reassembler) add(source string, fragment *message.Fragment) ([]byte, error) {
+	if fragment.Count == 0 || fragment.Count > maxFragments || fragment.Index >= fragment.Count {
+		return nil, fmt.Errorf("invalid fragment %d/%d from %s", fragment.Index, fragment.Count, source)
+	}
+
+	if r.pending == nil {
+		r.pending = make(map[string]*reassembly)
+	}
+	r.sweep()
+
+	key := fmt.Sprintf("%s/%d", source, fragment.Id)
+	current, found := r.pending[key]
+	if !found {
+		if len(r.pending) >= maxReassemblies {
+			return nil, fmt.Errorf("too many messages being reassembled, dropping fragment from %s", source)
+		}
+
+		current = &reassembly{fragments: make([][]byte, fragment.Count), startedAt: time.Now()}
+		r.pending[key] = current
+	}
+
+	if int(fragment.Count) != len(current.fragments) {
+		delete(r.pending, key)
+		return nil, fmt.Errorf("inconsistent fragment count for message %s", key)
+	}
+
+	if current.fragments[fragment.Index] != nil {
+		return nil, nil
+	}
+
+	current.fragments[fragment.Index] = fragment.Data
+	current.received++
+	current.size += len(fragment.Data)
+
+	if current.received < len(current.fragments) {
+		return nil, nil
+	}
+
+	delete(r.pending, key)
+	data := make([]byte, 0, current.size)
+	for _, part := range current.fragments {
+		data = append(data, part...)
+	}
+
+	return data, nil
+}
+
+// sweep drop messages not completed in time
+func (r *reassembler) sweep() {
+	if time.Since(r.lastSweep) < reassemblyTimeout/2 {
+		return
+	}
+	r.lastSweep = time.Now()
+
+	for key, current := range r.pending {
+		if time.Since(current.startedAt) > reassemblyTimeout {
+			delete(r.pending, key)
+		}
+	}
+}
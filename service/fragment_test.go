@@ -0,0 +1,176 @@
+package service
+
+import (
+	"bytes"
+	"github.com/tuarrep/sounddrop/message"
+	"github.com/tuarrep/sounddrop/util"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testServer server sending datagrams of given MTU
+func testServer(mtu int) *Server {
+	return &Server{sb: &util.ServiceBag{Config: &util.Config{Discover: &util.DiscoverConfig{MTU: mtu}, Mesh: &util.MeshConfig{}}}}
+}
+
+// splitMessage fragment data and decode fragments back
+func splitMessage(t *testing.T, srv *Server, data []byte) []*message.Fragment {
+	datagrams, err := srv.fragment(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fragments := make([]*message.Fragment, len(datagrams))
+	for i, datagram := range datagrams {
+		packet, err := message.FromBuffer(datagram)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fragments[i] = packet.Message.(*message.Fragment)
+	}
+
+	return fragments
+}
+
+func TestFragmentReassembleOutOfOrder(t *testing.T) {
+	data := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	fragments := splitMessage(t, testServer(1000), data)
+	if len(fragments) < 2 {
+		t.Fatalf("message split in %d fragments", len(fragments))
+	}
+
+	var r reassembler
+	for i := len(fragments) - 1; i > 0; i-- {
+		if whole, err := r.add("peer", fragments[i]); whole != nil || err != nil {
+			t.Fatalf("fragment %d completed message (%v)", i, err)
+		}
+	}
+
+	whole, err := r.add("peer", fragments[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(whole, data) {
+		t.Error("reassembled message differs")
+	}
+	if len(r.pending) != 0 {
+		t.Errorf("%d messages still pending", len(r.pending))
+	}
+}
+
+func TestFragmentDuplicate(t *testing.T) {
+	data := make([]byte, 3000)
+	fragments := splitMessage(t, testServer(1000), data)
+
+	var r reassembler
+	r.add("peer", fragments[0])
+	if whole, err := r.add("peer", fragments[0]); whole != nil || err != nil {
+		t.Fatalf("duplicated fragment gave %d bytes (%v)", len(whole), err)
+	}
+
+	var whole []byte
+	for _, fragment := range fragments[1:] {
+		whole, _ = r.add("peer", fragment)
+	}
+	if !bytes.Equal(whole, data) {
+		t.Error("reassembled message differs")
+	}
+}
+
+func TestFragmentSources(t *testing.T) {
+	fragments := splitMessage(t, testServer(1000), make([]byte, 1500))
+
+	var r reassembler
+	r.add("one", fragments[0])
+	if whole, _ := r.add("other", fragments[1]); whole != nil {
+		t.Error("fragments of different sources reassembled together")
+	}
+}
+
+func TestFragmentInvalid(t *testing.T) {
+	var r reassembler
+
+	invalid := []*message.Fragment{
+		{Id: 1, Index: 0, Count: 0},
+		{Id: 1, Index: 2, Count: 2},
+		{Id: 1, Index: 0, Count: maxFragments + 1},
+	}
+	for _, fragment := range invalid {
+		if _, err := r.add("peer", fragment); err == nil {
+			t.Errorf("fragment %d/%d accepted", fragment.Index, fragment.Count)
+		}
+	}
+
+	r.add("peer", &message.Fragment{Id: 2, Index: 0, Count: 3})
+	if _, err := r.add("peer", &message.Fragment{Id: 2, Index: 1, Count: 4}); err == nil {
+		t.Error("inconsistent fragment count accepted")
+	}
+}
+
+func TestFragmentOversize(t *testing.T) {
+	srv := testServer(1000)
+	if _, err := srv.fragment(make([]byte, 1000*maxFragments)); err == nil {
+		t.Error("message needing too many fragments split")
+	}
+
+	if _, err := testServer(50).fragment(make([]byte, 10)); err == nil {
+		t.Error("message split for a too small MTU")
+	}
+}
+
+func TestFragmentFitMTU(t *testing.T) {
+	defer message.SetMeshKey("")
+	defer message.SetMeshID("")
+
+	for _, mtu := range []int{300, 576, 1400, 9000} {
+		for _, key := range []string{"", "secret"} {
+			message.SetMeshKey(key)
+			message.SetMeshID(strings.Repeat("mesh", 16))
+
+			data := make([]byte, 10*mtu)
+			datagrams, err := testServer(mtu).fragment(data)
+			if err != nil {
+				t.Fatalf("MTU %d: %v", mtu, err)
+			}
+
+			for i, datagram := range datagrams {
+				if len(datagram) > mtu {
+					t.Errorf("MTU %d, key %q: fragment %d is %d bytes", mtu, key, i, len(datagram))
+				}
+			}
+		}
+	}
+}
+
+func TestFragmentTooManyReassemblies(t *testing.T) {
+	var r reassembler
+
+	for id := uint32(0); id < maxReassemblies; id++ {
+		if _, err := r.add("peer", &message.Fragment{Id: id, Index: 0, Count: 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := r.add("peer", &message.Fragment{Id: maxReassemblies, Index: 0, Count: 2}); err == nil {
+		t.Error("reassembly started beyond limit")
+	}
+}
+
+func TestFragmentTimeout(t *testing.T) {
+	var r reassembler
+
+	r.add("peer", &message.Fragment{Id: 1, Index: 0, Count: 2, Data: []byte{1}})
+	r.pending["peer/1"].startedAt = time.Now().Add(-2 * reassemblyTimeout)
+	r.lastSweep = time.Time{}
+
+	if whole, _ := r.add("peer", &message.Fragment{Id: 1, Index: 1, Count: 2, Data: []byte{2}}); whole != nil {
+		t.Error("message completed with a fragment of a timed out reassembly")
+	}
+	if _, found := r.pending["peer/1"]; !found {
+		t.Error("late fragment did not start a new reassembly")
+	}
+}
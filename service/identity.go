@@ -0,0 +1,88 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"fmt"
+	"github.com/tuarrep/sounddrop/message"
+	"github.com/tuarrep/sounddrop/util"
+	"time"
+)
+
+// announceSigningData bytes of an announce covered by its signature, every field but signature itself
+func announceSigningData(m *message.Announce) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString("sounddrop announce")
+
+	for _, field := range []string{m.DeviceName, m.MulticastGroup, m.MeshId} {
+		_ = binary.Write(&buffer, binary.BigEndian, uint32(len(field)))
+		buffer.WriteString(field)
+	}
+	_ = binary.Write(&buffer, binary.BigEndian, m.ServiceNumber)
+	_ = binary.Write(&buffer, binary.BigEndian, m.SignedAt)
+	buffer.Write(m.PublicKey)
+
+	return buffer.Bytes()
+}
+
+// signAnnounce add device public key and signature to an announce
+func (srv *Server) signAnnounce(m *message.Announce) {
+	m.PublicKey = srv.sb.Identity.PublicKey
+	m.SignedAt = time.Now().UnixNano()
+	m.Signature = srv.sb.Identity.Sign(announceSigningData(m))
+}
+
+// verifyAnnounce check an announce is signed by the owner of its device ID. With a mesh key, clocks of devices must
+// agree, it must also be signed recently enough not to be a replay
+func verifyAnnounce(m *message.Announce) error {
+	if util.DeviceIDOf(m.PublicKey).String() != m.DeviceName {
+		return fmt.Errorf("device %s does not own announced public key", m.DeviceName)
+	}
+
+	age := time.Since(time.Unix(0, m.SignedAt))
+	if message.Authenticated() && (age > maxPacketAge || age < -maxPacketAge) {
+		return fmt.Errorf("announce of %s signed %v ago, more than %v", m.DeviceName, age.Round(time.Second), maxPacketAge)
+	}
+
+	if !ed25519.Verify(m.PublicKey, announceSigningData(m), m.Signature) {
+		return fmt.Errorf("invalid announce signature of %s", m.DeviceName)
+	}
+
+	return nil
+}
+
+// deviceStatusSigningData bytes of a device status covered by its signature, mesh ID included so it cannot be replayed
+// in another mesh
+func deviceStatusSigningData(m *message.DeviceStatus, meshID string) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString("sounddrop device status")
+
+	for _, field := range []string{m.Id, meshID} {
+		_ = binary.Write(&buffer, binary.BigEndian, uint32(len(field)))
+		buffer.WriteString(field)
+	}
+	_ = binary.Write(&buffer, binary.BigEndian, m.Allowed)
+	buffer.Write(m.PublicKey)
+
+	return buffer.Bytes()
+}
+
+// signDeviceStatus add device public key and signature to a device status, so receivers know which device vouches
+func (msh *Mesher) signDeviceStatus(m *message.DeviceStatus) {
+	m.PublicKey = msh.sb.Identity.PublicKey
+	m.Signature = msh.sb.Identity.Sign(deviceStatusSigningData(m, msh.sb.Config.Mesh.ID))
+}
+
+// verifyDeviceStatus check a device status is signed by the owner of sender device ID
+func verifyDeviceStatus(m *message.DeviceStatus, sender string, meshID string) error {
+	if util.DeviceIDOf(m.PublicKey).String() != sender {
+		return fmt.Errorf("device %s does not own public key of its device status", sender)
+	}
+
+	if !ed25519.Verify(m.PublicKey, deviceStatusSigningData(m, meshID), m.Signature) {
+		return fmt.Errorf("invalid device status signature of %s", sender)
+	}
+
+	return nil
+}
@@ -0,0 +1,250 @@
+package service
+
+import (
+	"fmt"
+	"github.com/tuarrep/sounddrop/util"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"net"
+)
+
+// destination address a datagram is sent to, through a given interface when its index is not zero
+type destination struct {
+	address *net.UDPAddr
+	ifIndex int
+	peer    string
+}
+
+// interfaceNetworks host interface along with the networks it is directly connected to
+type interfaceNetworks struct {
+	iface    net.Interface
+	networks []*net.IPNet
+}
+
+// refreshInterfaces read host interfaces and their networks, looked up for received packets and refreshed every tick
+func (srv *Server) refreshInterfaces() {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		srv.log.Debug("Unable to list network interfaces: ", err)
+		return
+	}
+
+	table := make([]interfaceNetworks, 0, len(interfaces))
+	for _, iface := range interfaces {
+		entry := interfaceNetworks{iface: iface}
+		if addrs, err := iface.Addrs(); err == nil {
+			for _, a := range addrs {
+				if network, ok := a.(*net.IPNet); ok {
+					entry.networks = append(entry.networks, network)
+				}
+			}
+		}
+		table = append(table, entry)
+	}
+
+	srv.interfaceTable = table
+}
+
+// selectInterfaces list interfaces used for discovery according to include and exclude lists
+func (srv *Server) selectInterfaces() {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		srv.log.Warn("Unable to list network interfaces: ", err)
+		return
+	}
+
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+
+		if !isSelectedInterface(srv.sb.Config.Discover, iface) {
+			srv.log.Debug("Ignoring network interface ", iface.Name)
+			continue
+		}
+
+		srv.interfaces = append(srv.interfaces, iface)
+	}
+
+	if len(srv.interfaces) == 0 {
+		srv.log.Warn("No network interface selected, devices will not be discovered")
+	}
+}
+
+// isSelectedInterface whether interface is allowed by include and exclude lists. Loopback is only used when explicitly included
+func isSelectedInterface(config *util.DiscoverConfig, iface net.Interface) bool {
+	for _, name := range config.ExcludeInterfaces {
+		if name == iface.Name {
+			return false
+		}
+	}
+
+	if len(config.Interfaces) == 0 {
+		return iface.Flags&net.FlagLoopback == 0
+	}
+
+	for _, name := range config.Interfaces {
+		if name == iface.Name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// enableIngressInterface ask system for the interface each packet comes in through. Dual-stack socket gives it for IPv4
+// packets too, IPv4 only socket is asked next
+func (srv *Server) enableIngressInterface() {
+	if err := srv.pc6.SetControlMessage(ipv6.FlagInterface, true); err == nil {
+		srv.ingressIPv6 = true
+		return
+	}
+
+	if err := srv.pc4.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+		srv.log.Warn("Unable to know interface of received packets, devices are discovered on every interface: ", err)
+	}
+}
+
+// readFrom read a datagram along with index of the interface it came in through, zero when unknown
+func (srv *Server) readFrom(buf []byte) (int, *net.UDPAddr, int, error) {
+	var n, ifIndex int
+	var source net.Addr
+	var err error
+
+	if srv.ingressIPv6 {
+		var cm *ipv6.ControlMessage
+		n, cm, source, err = srv.pc6.ReadFrom(buf)
+		if cm != nil {
+			ifIndex = cm.IfIndex
+		}
+	} else {
+		var cm *ipv4.ControlMessage
+		n, cm, source, err = srv.pc4.ReadFrom(buf)
+		if cm != nil {
+			ifIndex = cm.IfIndex
+		}
+	}
+
+	if err != nil {
+		return 0, nil, 0, err
+	}
+
+	addr, ok := source.(*net.UDPAddr)
+	if !ok {
+		return 0, nil, 0, fmt.Errorf("unexpected source address %v", source)
+	}
+
+	return n, addr, ifIndex, nil
+}
+
+// isSelectedIndex whether packets coming in through interface of given index are used for discovery, routed ones included.
+// Index is zero when system does not tell it, such packets are accepted
+func (srv *Server) isSelectedIndex(ifIndex int) bool {
+	if ifIndex == 0 {
+		return true
+	}
+
+	for _, iface := range srv.interfaces {
+		if iface.Index == ifIndex {
+			return true
+		}
+	}
+
+	return false
+}
+
+// onLinkIndex index of the interface a packet from addr came in through when addr is directly reachable on it,
+// zero for routed addresses which are left to system routing
+func (srv *Server) onLinkIndex(addr *net.UDPAddr, ifIndex int) int {
+	for _, entry := range srv.interfaceTable {
+		if entry.iface.Index != ifIndex {
+			continue
+		}
+
+		if addr.IP.IsLinkLocalUnicast() {
+			return ifIndex
+		}
+
+		for _, network := range entry.networks {
+			if network.Contains(addr.IP) {
+				return ifIndex
+			}
+		}
+	}
+
+	return 0
+}
+
+// interfaceFor host interface on which address is directly reachable, nil when address is routed
+func (srv *Server) interfaceFor(addr *net.UDPAddr) *net.Interface {
+	for i, entry := range srv.interfaceTable {
+		if addr.Zone != "" {
+			if entry.iface.Name == addr.Zone {
+				return &srv.interfaceTable[i].iface
+			}
+			continue
+		}
+
+		for _, network := range entry.networks {
+			if network.Contains(addr.IP) {
+				return &srv.interfaceTable[i].iface
+			}
+		}
+	}
+
+	return nil
+}
+
+// broadcastAddresses IPv4 broadcast address of each subnet of selected interfaces
+func (srv *Server) broadcastAddresses() []destination {
+	var destinations []destination
+
+	for _, iface := range srv.interfaces {
+		if iface.Flags&net.FlagBroadcast == 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, a := range addrs {
+			network, ok := a.(*net.IPNet)
+			if !ok || network.IP.To4() == nil || len(network.Mask) != net.IPv4len {
+				continue
+			}
+
+			broadcast := make(net.IP, net.IPv4len)
+			for i := range broadcast {
+				broadcast[i] = network.IP.To4()[i] | ^network.Mask[i]
+			}
+			destinations = append(destinations, destination{address: &net.UDPAddr{IP: broadcast, Port: srv.sb.Config.Discover.Port}, ifIndex: iface.Index})
+		}
+	}
+
+	return destinations
+}
+
+// writeTo send datagram to a destination, through its interface when known
+func (srv *Server) writeTo(datagram []byte, to destination) error {
+	if to.ifIndex == 0 {
+		_, err := srv.sc.WriteToUDP(datagram, to.address)
+		return err
+	}
+
+	// Dual-stack socket only takes IPv4 packet info for IPv4 destinations
+	var err error
+	if to.address.IP.To4() != nil {
+		_, err = srv.pc4.WriteTo(datagram, &ipv4.ControlMessage{IfIndex: to.ifIndex}, to.address)
+	} else {
+		_, err = srv.pc6.WriteTo(datagram, &ipv6.ControlMessage{IfIndex: to.ifIndex}, to.address)
+	}
+
+	if err != nil {
+		srv.log.Debug(fmt.Sprintf("Unable to send through interface %d (%v), letting system route it", to.ifIndex, err))
+		_, err = srv.sc.WriteToUDP(datagram, to.address)
+	}
+
+	return err
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"fmt"
+	"net"
+)
+
+// Link-local multicast group used to announce device on IPv6 networks
+var announceGroupIPv6 = net.ParseIP("ff02::1:9416")
+
+// joinAnnounceGroup subscribe server socket to IPv6 announce group on every selected multicast capable interface
+func (srv *Server) joinAnnounceGroup() {
+	for i := range srv.interfaces {
+		if srv.interfaces[i].Flags&net.FlagMulticast == 0 {
+			continue
+		}
+
+		if err := srv.pc6.JoinGroup(&srv.interfaces[i], &net.UDPAddr{IP: announceGroupIPv6}); err != nil {
+			srv.log.Debug(fmt.Sprintf("Unable to join IPv6 announce group on %s: %v", srv.interfaces[i].Name, err))
+			continue
+		}
+		srv.announceInterfaces = append(srv.announceInterfaces, srv.interfaces[i])
+	}
+
+	if len(srv.announceInterfaces) == 0 {
+		srv.log.Info("IPv6 announce group not joined, discovery limited to IPv4 broadcast")
+		return
+	}
+
+	if err := srv.pc6.SetMulticastLoopback(false); err != nil {
+		srv.log.Debug("Unable to disable IPv6 multicast loopback: ", err)
+	}
+	if err := srv.pc6.SetMulticastHopLimit(1); err != nil {
+		srv.log.Debug("Unable to set IPv6 multicast hop limit: ", err)
+	}
+
+	srv.log.Info(fmt.Sprintf("Joined IPv6 announce group %s on %d interfaces", announceGroupIPv6, len(srv.announceInterfaces)))
+}
+
+// announceAddresses addresses announces are sent to: IPv4 broadcast and IPv6 announce group of each selected interface,
+// then peers announced to directly
+func (srv *Server) announceAddresses() []destination {
+	destinations := srv.broadcastAddresses()
+
+	// Link-local group needs the interface zone to be routed
+	for _, iface := range srv.announceInterfaces {
+		address := &net.UDPAddr{IP: announceGroupIPv6, Port: srv.sb.Config.Discover.Port, Zone: iface.Name}
+		destinations = append(destinations, destination{address: address, ifIndex: iface.Index})
+	}
+
+	return append(destinations, srv.unicastAnnounceAddresses()...)
+}
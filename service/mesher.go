@@ -1,17 +1,20 @@
 package service
 
 import (
+	"fmt"
 	"github.com/golang/protobuf/proto"
 	"github.com/sirupsen/logrus"
 	"github.com/tuarrep/sounddrop/message"
 	"github.com/tuarrep/sounddrop/util"
+	"time"
 )
 
 // Device mesh device
 type Device struct {
-	id      string
-	online  bool
-	allowed bool
+	id          string
+	fingerprint string
+	online      bool
+	allowed     bool
 }
 
 // Mesher mesher service
@@ -21,6 +24,12 @@ type Mesher struct {
 	log       *logrus.Entry
 	devices   map[string]*Device
 	sb        *util.ServiceBag
+
+	pin         string
+	pinAttempts int
+	pinLocks    int
+	pinUnlockAt time.Time
+	pairings    map[string]*pairing
 }
 
 // Stop clean service when stopped by supervisor
@@ -37,31 +46,69 @@ func (msh *Mesher) Serve() {
 	msh.sb = util.GetServiceBag()
 	msh.message = make(chan proto.Message)
 	msh.devices = make(map[string]*Device)
+	msh.pairings = make(map[string]*pairing)
 
-	msh.Messenger.RegisterSome([]byte{message.PeerOnlineMessage, message.PeerOfflineMessage, message.DeviceStatusMessage}, msh)
+	msh.Messenger.RegisterSome([]byte{message.PeerOnlineMessage, message.PeerOfflineMessage, message.DeviceStatusMessage, message.PairRequestMessage, message.PairChallengeMessage, message.PairResponseMessage}, msh)
 
 	msh.devices[msh.sb.DeviceID.String()] = &Device{id: msh.sb.DeviceID.String(), online: true, allowed: msh.sb.Config.Mesh.AutoAccept}
+	if !msh.sb.Config.Mesh.AutoAccept && message.Authenticated() {
+		msh.newPin()
+	} else if !msh.sb.Config.Mesh.AutoAccept {
+		msh.log.Info("Pairing with a PIN needs a mesh key, accept this device with -trusted on the others")
+	}
+	msh.notifyAccepted()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 
 	for {
 		select {
-		case msg := <-msh.message:
+		case <-ticker.C:
+			msh.expirePairings()
+			msh.unlockPairing()
+		case packet := <-msh.message:
+			msg, envelope := message.Unwrap(packet)
 			switch m := msg.(type) {
 			case *message.PeerOnline:
 				msh.handlePeerOnline(m)
 			case *message.PeerOffline:
 				msh.handlePeerOffline(m)
 			case *message.DeviceStatus:
-				msh.handleDeviceStatus(m)
+				msh.handleDeviceStatus(m, envelope)
+			case *message.PairRequest:
+				msh.handlePairRequest(m)
+			case *message.PairChallenge:
+				msh.handlePairChallenge(m, envelope)
+			case *message.PairResponse:
+				msh.handlePairResponse(m, envelope)
 			}
 		}
 	}
 }
 
-func (msh *Mesher) handleDeviceStatus(m *message.DeviceStatus) {
+func (msh *Mesher) handleDeviceStatus(m *message.DeviceStatus, envelope *message.Envelope) {
+	// Only accepted devices which proved their identity may vouch for others, with the key they announce
+	if envelope == nil {
+		return
+	}
+	sender, found := msh.devices[envelope.Sender]
+	if !found || !sender.allowed || sender.fingerprint == "" {
+		return
+	}
+	if err := verifyDeviceStatus(m, envelope.Sender, msh.sb.Config.Mesh.ID); err != nil {
+		msh.log.Warn("Ignoring device status: ", err)
+		return
+	}
+	if util.Fingerprint(m.PublicKey) != sender.fingerprint {
+		msh.log.Warn(fmt.Sprintf("Ignoring device status of %s signed with another key than the announced one", envelope.Sender))
+		return
+	}
+
 	if device, found := msh.devices[m.Id]; found && device.allowed == false {
 		device.allowed = m.Allowed
 		msh.devices[m.Id] = device
 		msh.log.Warn("Accepted device ", m.Id)
+		msh.notifyAccepted()
 	}
 }
 
@@ -78,27 +125,71 @@ func (msh *Mesher) handlePeerOnline(m *message.PeerOnline) {
 		device.online = true
 		msh.devices[m.Id] = device
 		msh.log.Warn("Online device ", m.Id)
+
+		if device.fingerprint == "" && m.Fingerprint != "" {
+			device.fingerprint = m.Fingerprint
+			msh.checkTrust(device)
+		}
 	} else {
-		device := &Device{id: m.Id, online: true, allowed: msh.sb.Config.Mesh.AutoAccept}
+		device := &Device{id: m.Id, fingerprint: m.Fingerprint, online: true, allowed: msh.sb.Config.Mesh.AutoAccept}
 		msh.devices[m.Id] = device
 		msh.log.Debug("New device ", device.id)
 
 		if msh.sb.Config.Mesh.AutoAccept {
 			msh.log.Warn("Auto accepting device ", m.Id)
 			msh.sendMeshState()
+		} else {
+			msh.checkTrust(device)
 		}
 	}
 }
 
+// checkTrust accept an identified device when its fingerprint is trusted, otherwise tell user how to pair it
+func (msh *Mesher) checkTrust(device *Device) {
+	if device.allowed || device.fingerprint == "" {
+		return
+	}
+
+	for _, trusted := range msh.sb.Config.Mesh.Trusted {
+		if util.SameFingerprint(trusted, device.fingerprint) {
+			device.allowed = true
+			msh.log.Warn(fmt.Sprintf("Accepted trusted device %s (%s)", device.id, device.fingerprint))
+			msh.sendMeshState()
+			return
+		}
+	}
+
+	msh.log.Warn(fmt.Sprintf("Device %s has fingerprint %s. If it matches the one it shows, add it to -trusted or pair it with its PIN to accept it", device.id, device.fingerprint))
+}
+
 // GetChan returns messaging chan
 func (msh *Mesher) GetChan() chan proto.Message {
 	return msh.message
 }
 
 func (msh *Mesher) sendMeshState() {
+	msh.notifyAccepted()
+
 	for _, device := range msh.devices {
 		notification := &message.DeviceStatus{Id: device.id, Allowed: device.allowed}
+		msh.signDeviceStatus(notification)
 		notificationData, _ := message.ToBuffer(notification)
-		msh.Messenger.Message <- &message.WriteRequest{DeviceName: "*", Message: notificationData}
+		msh.Messenger.Message <- &message.WriteRequest{DeviceName: "*", Message: notificationData, Reliable: true}
 	}
 }
+
+// notifyAccepted tell server which devices are accepted or being paired, encryption keys are negotiated with them.
+// Devices are never refused once accepted, so notifications may be received in any order
+func (msh *Mesher) notifyAccepted() {
+	accepted := &message.AcceptedDevices{Accepted: msh.devices[msh.sb.DeviceID.String()].allowed}
+	for id, device := range msh.devices {
+		if device.allowed {
+			accepted.Devices = append(accepted.Devices, id)
+		}
+	}
+	for id := range msh.pairings {
+		accepted.Pairing = append(accepted.Pairing, id)
+	}
+
+	msh.send(accepted)
+}
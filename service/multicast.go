@@ -0,0 +1,62 @@
+package service
+
+import (
+	"fmt"
+	"net"
+)
+
+// joinMulticastGroup subscribe server socket to audio multicast group on every selected multicast capable interface
+func (srv *Server) joinMulticastGroup() {
+	if srv.sb.Config.Discover.MulticastGroup == "" {
+		return
+	}
+
+	group := net.ParseIP(srv.sb.Config.Discover.MulticastGroup)
+	if group == nil || group.To4() == nil || !group.IsMulticast() {
+		srv.log.Warn(fmt.Sprintf("%s is not an IPv4 multicast address, audio will be sent to each peer", srv.sb.Config.Discover.MulticastGroup))
+		return
+	}
+
+	joined := 0
+	for i := range srv.interfaces {
+		if srv.interfaces[i].Flags&net.FlagMulticast == 0 {
+			continue
+		}
+
+		if err := srv.pc4.JoinGroup(&srv.interfaces[i], &net.UDPAddr{IP: group}); err != nil {
+			srv.log.Debug(fmt.Sprintf("Unable to join multicast group %s on %s: %v", group, srv.interfaces[i].Name, err))
+			continue
+		}
+		joined++
+	}
+
+	if joined == 0 {
+		srv.log.Warn(fmt.Sprintf("Unable to join multicast group %s, audio will be received by unicast", group))
+		return
+	}
+
+	// Our own player already receives our stream through messenger
+	if err := srv.pc4.SetMulticastLoopback(false); err != nil {
+		srv.log.Debug("Unable to disable multicast loopback: ", err)
+	}
+	if err := srv.pc4.SetMulticastTTL(1); err != nil {
+		srv.log.Debug("Unable to set multicast TTL: ", err)
+	}
+
+	srv.multicastGroup = &net.UDPAddr{IP: group, Port: srv.sb.Config.Discover.Port}
+	srv.log.Info(fmt.Sprintf("Joined multicast group %s on %d interfaces", group, joined))
+}
+
+// multicastAddresses addresses to use to deliver a message to every peer: multicast group once,
+// then each peer that did not announce our group
+func (srv *Server) multicastAddresses() []destination {
+	destinations := []destination{{address: srv.multicastGroup}}
+
+	for _, peer := range srv.peers {
+		if peer.multicastGroup != srv.multicastGroup.IP.String() {
+			destinations = append(destinations, peer.destination())
+		}
+	}
+
+	return destinations
+}
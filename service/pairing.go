@@ -0,0 +1,249 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/tuarrep/sounddrop/message"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Number of digits of pairing PIN
+const pinDigits = 6
+
+// Delay during which a paired device must answer the challenge
+const pairingTimeout = 30 * time.Second
+
+// Number of challenges with a wrong PIN received before pairing is locked, so PIN cannot be guessed
+const maxPairAttempts = 3
+
+// Delay during which pairing is locked after too many wrong PINs, doubled at each lock
+const pairingLockout = 1 * time.Minute
+
+// Longest delay during which pairing is locked
+const maxPairingLockout = 1 * time.Hour
+
+// pairing challenge sent to a device, waiting for its answer
+type pairing struct {
+	pin       string
+	nonce     []byte
+	expiresAt time.Time
+}
+
+// newPin draw a new pairing PIN and show it to user
+func (msh *Mesher) newPin() {
+	max := big.NewInt(1)
+	for i := 0; i < pinDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		msh.log.Warn("Unable to draw pairing PIN: ", err)
+		return
+	}
+
+	msh.pin = fmt.Sprintf("%0*d", pinDigits, n)
+	msh.pinAttempts = 0
+	msh.log.Warn(fmt.Sprintf("Pairing PIN of this device: %s. Enter it on an accepted device to join the mesh", msh.pin))
+}
+
+// lockPairing stop answering challenges after too many wrong PINs, for longer each time
+func (msh *Mesher) lockPairing() {
+	lockout := pairingLockout << uint(msh.pinLocks)
+	if lockout > maxPairingLockout || lockout <= 0 {
+		lockout = maxPairingLockout
+	} else {
+		msh.pinLocks++
+	}
+
+	msh.pin = ""
+	msh.pinUnlockAt = time.Now().Add(lockout)
+	msh.log.Warn(fmt.Sprintf("Too many wrong PINs, pairing is locked for %v", lockout))
+}
+
+// unlockPairing draw a new PIN once pairing lockout is over
+func (msh *Mesher) unlockPairing() {
+	if msh.pinUnlockAt.IsZero() || time.Now().Before(msh.pinUnlockAt) {
+		return
+	}
+
+	msh.pinUnlockAt = time.Time{}
+	if !msh.devices[msh.sb.DeviceID.String()].allowed {
+		msh.newPin()
+	}
+}
+
+// handlePairRequest challenge a device to prove it shows the PIN entered by user, proving we know it too
+func (msh *Mesher) handlePairRequest(m *message.PairRequest) {
+	// Without mesh key, anyone could answer challenge and guess PIN from it
+	if !message.Authenticated() {
+		msh.pairResult(m.Device, false, "pairing needs a mesh key")
+		return
+	}
+
+	// Devices only trust mesh state of accepted devices, pairing would not get challenged device accepted
+	if !msh.devices[msh.sb.DeviceID.String()].allowed {
+		msh.pairResult(m.Device, false, "this device is not accepted in mesh yet")
+		return
+	}
+
+	device, err := msh.findDevice(m.Device)
+	if err != nil {
+		msh.pairResult(m.Device, false, err.Error())
+		return
+	}
+
+	if device.allowed {
+		msh.pairResult(device.id, false, "device is already accepted")
+		return
+	}
+
+	// Answer must come from the owner of device key, otherwise pairing proves nothing
+	if device.fingerprint == "" {
+		msh.pairResult(device.id, false, "device did not prove its identity yet")
+		return
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		msh.pairResult(device.id, false, err.Error())
+		return
+	}
+
+	pin := strings.TrimSpace(m.Pin)
+	msh.pairings[device.id] = &pairing{pin: pin, nonce: nonce, expiresAt: time.Now().Add(pairingTimeout)}
+	msh.log.Info("Pairing with device ", device.id)
+	msh.notifyAccepted()
+
+	proof := pairProof("challenge", pin, nonce, msh.sb.DeviceID.String(), device.id)
+	data, _ := message.ToBuffer(&message.PairChallenge{Nonce: nonce, Proof: proof})
+	msh.send(&message.WriteRequest{DeviceName: device.id, Message: data, Reliable: true})
+}
+
+// handlePairChallenge prove to the challenging device we know our PIN, as long as we are not accepted yet.
+// Challenger must prove it knows our PIN first: user entered it there, so we accept challenger in return
+func (msh *Mesher) handlePairChallenge(m *message.PairChallenge, envelope *message.Envelope) {
+	if envelope == nil || msh.pin == "" || msh.devices[msh.sb.DeviceID.String()].allowed {
+		return
+	}
+
+	// Answer must go to the owner of challenger key, otherwise accepting it proves nothing
+	challenger, found := msh.devices[envelope.Sender]
+	if !found || challenger.fingerprint == "" {
+		return
+	}
+
+	expected := pairProof("challenge", msh.pin, m.Nonce, envelope.Sender, msh.sb.DeviceID.String())
+	if !hmac.Equal(expected, m.Proof) {
+		msh.log.Warn("Wrong PIN entered on device ", envelope.Sender)
+		msh.pinAttempts++
+		if msh.pinAttempts >= maxPairAttempts {
+			msh.lockPairing()
+		}
+		return
+	}
+
+	if !challenger.allowed {
+		challenger.allowed = true
+		msh.log.Warn(fmt.Sprintf("Accepted device %s, it knows our pairing PIN", challenger.id))
+		msh.notifyAccepted()
+	}
+
+	proof := pairProof("response", msh.pin, m.Nonce, msh.sb.DeviceID.String(), envelope.Sender)
+	data, _ := message.ToBuffer(&message.PairResponse{Nonce: m.Nonce, Proof: proof})
+	msh.send(&message.WriteRequest{DeviceName: envelope.Sender, Message: data, Reliable: true})
+
+	// PIN was shown to pair this device once, it is not used again
+	msh.pin = ""
+}
+
+// handlePairResponse accept challenged device when its proof matches the PIN entered by user
+func (msh *Mesher) handlePairResponse(m *message.PairResponse, envelope *message.Envelope) {
+	if envelope == nil {
+		return
+	}
+
+	p, found := msh.pairings[envelope.Sender]
+	if !found || !hmac.Equal(p.nonce, m.Nonce) {
+		return
+	}
+	delete(msh.pairings, envelope.Sender)
+
+	expected := pairProof("response", p.pin, p.nonce, envelope.Sender, msh.sb.DeviceID.String())
+	if !hmac.Equal(expected, m.Proof) {
+		msh.log.Warn("Wrong PIN for device ", envelope.Sender)
+		msh.pairResult(envelope.Sender, false, "wrong PIN")
+		return
+	}
+
+	device, found := msh.devices[envelope.Sender]
+	if !found {
+		return
+	}
+
+	device.allowed = true
+	msh.log.Warn("Paired device ", device.id)
+	msh.pairResult(device.id, true, "")
+	msh.sendMeshState()
+}
+
+// expirePairings give up pairings left unanswered
+func (msh *Mesher) expirePairings() {
+	for id, p := range msh.pairings {
+		if time.Now().After(p.expiresAt) {
+			delete(msh.pairings, id)
+			msh.pairResult(id, false, "device did not answer, PIN may be wrong")
+		}
+	}
+}
+
+// findDevice known device by ID or unique ID prefix
+func (msh *Mesher) findDevice(id string) (*Device, error) {
+	if device, found := msh.devices[id]; found && id != msh.sb.DeviceID.String() {
+		return device, nil
+	}
+
+	var match *Device
+	for deviceID, device := range msh.devices {
+		if id == "" || deviceID == msh.sb.DeviceID.String() || !strings.HasPrefix(deviceID, id) {
+			continue
+		}
+		if match != nil {
+			return nil, fmt.Errorf("several devices match %s", id)
+		}
+		match = device
+	}
+
+	if match == nil {
+		return nil, fmt.Errorf("unknown device %s", id)
+	}
+
+	return match, nil
+}
+
+func (msh *Mesher) pairResult(id string, paired bool, reason string) {
+	msh.send(&message.PairResult{Device: id, Paired: paired, Reason: reason})
+}
+
+// send message to messenger without blocking it, it may be waiting for us to read next message
+func (msh *Mesher) send(msg proto.Message) {
+	go func() {
+		msh.Messenger.Message <- msg
+	}()
+}
+
+// pairProof proof of PIN knowledge bound to a challenge, both devices and the pairing step, so it cannot be reflected
+func pairProof(step string, pin string, nonce []byte, prover string, verifier string) []byte {
+	mac := hmac.New(sha256.New, []byte(pin))
+	mac.Write([]byte("sounddrop pair " + step))
+	mac.Write(nonce)
+	mac.Write([]byte(prover))
+	mac.Write([]byte(verifier))
+
+	return mac.Sum(nil)
+}
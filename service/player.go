@@ -6,10 +6,12 @@ import (
 	"github.com/faiface/beep/speaker"
 	"github.com/golang/protobuf/proto"
 	"github.com/sirupsen/logrus"
+	"github.com/tuarrep/sounddrop/codec"
 	"github.com/tuarrep/sounddrop/message"
 	"github.com/tuarrep/sounddrop/structure"
 	"github.com/tuarrep/sounddrop/util"
 	"math"
+	"sort"
 	"time"
 )
 
@@ -18,9 +20,47 @@ type Player struct {
 	Message   chan proto.Message
 	log       *logrus.Entry
 	Messenger *Messenger
+	sb        *util.ServiceBag
 	format    beep.Format
 	tsq       *structure.TimedSampleQueue
 	silence   beep.Streamer
+	streams   map[uint32]*playerStream
+	clocks    map[string]*util.MeshClock
+	drift     *driftCompensator
+}
+
+// Longest gap filled by packet loss concealment. Longer gaps are considered as stream interruptions
+const maxConcealment = 500 * time.Millisecond
+
+// Maximum number of packets held waiting for missing ones
+const maxHeldPackets = 1024
+
+// Delay before its playing time after which a held packet is played even if packets before it are still missing
+const holdMargin = 1 * time.Second
+
+// Number of played packets payloads kept to rebuild lost packets from parity
+const payloadHistory = 256
+
+// Minimum delay between two retransmission requests for a stream
+const nackInterval = 200 * time.Millisecond
+
+// playerStream state of one received audio stream
+type playerStream struct {
+	id         uint32
+	tracker    structure.SequenceTracker
+	decoders   map[message.AudioCodec]codec.Decoder
+	last       [][2]float64
+	lastNextAt int64
+	pending    map[uint64]heldFrame
+	payloads   map[uint64][]byte
+	lastNack   time.Time
+	source     string
+}
+
+// heldFrame packet waiting for the ones before it
+type heldFrame struct {
+	msg    proto.Message
+	nextAt int64
 }
 
 // Stop clean service when stopped by supervisor
@@ -33,30 +73,317 @@ func (p *Player) Serve() {
 	p.log = util.GetContextLogger("service/player.go", "Services/Player")
 	p.log.Info("Player starting...")
 
+	p.sb = util.GetServiceBag()
 	p.Message = make(chan proto.Message)
-	p.Messenger.Register(message.StreamDataMessage, p)
-	p.format = beep.Format{SampleRate: 44100, NumChannels: 2, Precision: 2}
+	p.Messenger.RegisterSome([]byte{message.StreamDataMessage, message.EncodedStreamDataMessage, message.StreamParityMessage, message.ClockOffsetMessage}, p)
+	p.format = beep.Format{SampleRate: beep.SampleRate(p.sb.Config.Streamer.ResamplingRate), NumChannels: 2, Precision: 2}
+	p.streams = make(map[uint32]*playerStream)
+	p.clocks = make(map[string]*util.MeshClock)
 	p.tsq = structure.NewTimedSampleQueue(10 * int(p.format.SampleRate))
 	p.silence = beep.Silence(-1)
 
 	_ = speaker.Init(p.format.SampleRate, 512)
+	p.drift = newDriftCompensator(p, p.format.SampleRate, p.sb.Config.Streamer.ResamplingQuality, p.log)
 	speaker.Play(beep.Seq(beep.Callback(func() {
 		//p.tsq.Start()
-	}), p, beep.Callback(func() {
+	}), p.drift, beep.Callback(func() {
 		p.log.Warn("Speaker ended stream. This should not have happened!")
 	})))
 
+	ticker := time.NewTicker(100 * time.Millisecond)
+
 	for {
 		select {
-		case msg := <-p.Message:
+		case packet := <-p.Message:
+			msg, envelope := message.Unwrap(packet)
 			switch m := msg.(type) {
 			case *message.StreamData:
-				for index, sample := range m.SamplesLeft {
-					samples := [2]float64{sample, m.SamplesRight[index]}
-					p.tsq.Add(samples, m.NextAt+int64(p.format.SampleRate.D(index)*time.Nanosecond))
+				p.setSource(m.StreamId, envelope)
+				p.handleFrame(m, m.StreamId, m.Sequence, p.localTime(envelope, m.NextAt))
+			case *message.EncodedStreamData:
+				p.setSource(m.StreamId, envelope)
+				p.handleFrame(m, m.StreamId, m.Sequence, p.localTime(envelope, m.NextAt))
+			case *message.StreamParity:
+				p.handleParity(m, envelope)
+			case *message.ClockOffset:
+				p.clocks[m.Device] = &util.MeshClock{Offset: m.Offset, Drift: m.Drift, Reference: m.Reference}
+			}
+		case <-ticker.C:
+			for _, stream := range p.streams {
+				p.release(stream)
+			}
+		}
+	}
+}
+
+func (p *Player) getStream(streamID uint32) *playerStream {
+	stream, found := p.streams[streamID]
+	if !found {
+		stream = &playerStream{
+			id:       streamID,
+			decoders: make(map[message.AudioCodec]codec.Decoder),
+			pending:  make(map[uint64]heldFrame),
+			payloads: make(map[uint64][]byte),
+		}
+		p.streams[streamID] = stream
+		p.log.Info(fmt.Sprintf("Receiving new stream %08x", streamID))
+	}
+
+	return stream
+}
+
+// setSource remember the device sending a stream so retransmissions can be requested to it only
+func (p *Player) setSource(streamID uint32, envelope *message.Envelope) {
+	if envelope != nil && envelope.Sender != "" {
+		p.getStream(streamID).source = envelope.Sender
+	}
+}
+
+// localTime convert a time of sender clock to our clock. Sender clock is trusted when not synchronized yet
+func (p *Player) localTime(envelope *message.Envelope, remote int64) int64 {
+	if envelope == nil {
+		return remote
+	}
+
+	return p.clocks[envelope.Sender].ToLocal(remote)
+}
+
+func (p *Player) handleFrame(msg proto.Message, streamID uint32, sequence uint64, nextAt int64) {
+	stream := p.getStream(streamID)
+
+	if _, held := stream.pending[sequence]; held {
+		stream.tracker.Duplicated++
+		return
+	}
+
+	if next, started := stream.tracker.Next(); started && sequence < next {
+		stream.tracker.Track(sequence)
+		p.log.Debug(fmt.Sprintf("Dropping late or duplicated packet %d of stream %08x (reordered=%d, duplicated=%d)", sequence, streamID, stream.tracker.Reordered, stream.tracker.Duplicated))
+		return
+	}
+
+	if payload, err := codec.FramePayload(msg); err == nil {
+		stream.payloads[sequence] = payload
+	}
+
+	// Packets missing before a jump this far cannot be waited for, play what is held and restart from this one
+	if next, started := stream.tracker.Next(); started && sequence-next >= maxHeldPackets {
+		p.log.Warn(fmt.Sprintf("Stream %08x jumped from packet %d to %d, resynchronizing", streamID, next, sequence))
+		p.flush(stream)
+		p.play(stream, msg, nextAt, sequence)
+		return
+	}
+
+	stream.pending[sequence] = heldFrame{msg: msg, nextAt: nextAt}
+	p.release(stream)
+}
+
+func (p *Player) handleParity(m *message.StreamParity, envelope *message.Envelope) {
+	stream := p.getStream(m.StreamId)
+
+	payloads := make([][]byte, len(m.Lengths))
+	missing := 0
+	for i := range payloads {
+		if payload, found := stream.payloads[m.FirstSequence+uint64(i)]; found {
+			payloads[i] = payload
+		} else {
+			missing++
+		}
+	}
+
+	if missing != 1 {
+		return
+	}
+
+	msg, err := codec.RecoverFrame(m, payloads)
+	if err != nil {
+		p.log.Warn("Unable to recover lost packet: ", err)
+		return
+	}
+
+	for i, payload := range payloads {
+		if payload == nil {
+			sequence := m.FirstSequence + uint64(i)
+			if next, started := stream.tracker.Next(); started && sequence < next {
+				p.log.Debug(fmt.Sprintf("Packet %d of stream %08x recovered too late", sequence, m.StreamId))
+				return
+			}
+
+			p.log.Debug(fmt.Sprintf("Recovered packet %d of stream %08x from parity", sequence, m.StreamId))
+			stream.payloads[sequence], _ = codec.FramePayload(msg)
+			stream.pending[sequence] = heldFrame{msg: msg, nextAt: p.localTime(envelope, m.NextAts[i])}
+		}
+	}
+
+	p.release(stream)
+}
+
+// release play held packets in sequence order. When one is missing, ask for it and wait for it to be received
+// or recovered unless too many packets are held or the next one is about to be played
+func (p *Player) release(stream *playerStream) {
+	for len(stream.pending) > 0 {
+		sequence, started := stream.tracker.Next()
+
+		if _, found := stream.pending[sequence]; !found || !started {
+			lowest := uint64(math.MaxUint64)
+			for held := range stream.pending {
+				if held < lowest {
+					lowest = held
 				}
 			}
+
+			deadline := stream.pending[lowest].nextAt - holdMargin.Nanoseconds()
+			if started && len(stream.pending) <= maxHeldPackets && util.Now() < deadline {
+				p.requestMissing(stream, sequence)
+				return
+			}
+			sequence = lowest
+		}
+
+		frame := stream.pending[sequence]
+		delete(stream.pending, sequence)
+		p.play(stream, frame.msg, frame.nextAt, sequence)
+	}
+}
+
+// flush play all held packets in sequence order without waiting for missing ones
+func (p *Player) flush(stream *playerStream) {
+	sequences := make([]uint64, 0, len(stream.pending))
+	for sequence := range stream.pending {
+		sequences = append(sequences, sequence)
+	}
+	sort.Slice(sequences, func(i, j int) bool { return sequences[i] < sequences[j] })
+
+	for _, sequence := range sequences {
+		frame := stream.pending[sequence]
+		delete(stream.pending, sequence)
+		p.play(stream, frame.msg, frame.nextAt, sequence)
+	}
+}
+
+// requestMissing ask stream source to send again packets missing before held ones, at most maxHeldPackets after next
+func (p *Player) requestMissing(stream *playerStream, next uint64) {
+	if time.Since(stream.lastNack) < nackInterval {
+		return
+	}
+	stream.lastNack = time.Now()
+
+	highest := next
+	for held := range stream.pending {
+		if held > highest && held-next <= maxHeldPackets {
+			highest = held
+		}
+	}
+
+	var ranges []*message.SequenceRange
+	for sequence := next; sequence < highest; sequence++ {
+		if _, held := stream.pending[sequence]; held {
+			continue
+		}
+
+		if len(ranges) > 0 && ranges[len(ranges)-1].Last == sequence-1 {
+			ranges[len(ranges)-1].Last = sequence
+		} else if len(ranges) < maxNackRanges {
+			ranges = append(ranges, &message.SequenceRange{First: sequence, Last: sequence})
+		} else {
+			break
+		}
+	}
+
+	nack := &message.StreamNack{StreamId: stream.id, DeviceName: p.sb.DeviceID.String(), Missing: ranges}
+	nackData, _ := message.ToBuffer(nack)
+
+	target := stream.source
+	if target == "" {
+		target = "*"
+	}
+
+	// Messenger may be waiting for us to read next message, do not block it
+	go func() {
+		p.Messenger.Message <- &message.WriteRequest{DeviceName: target, Message: nackData}
+	}()
+}
+
+func (p *Player) play(stream *playerStream, msg proto.Message, nextAt int64, sequence uint64) {
+	missing, _ := stream.tracker.Track(sequence)
+	p.forgetPayloads(stream)
+
+	samples, err := p.decode(stream, msg)
+	if err != nil {
+		p.log.Warn("Unable to decode stream data: ", err)
+		return
+	}
+
+	if missing > 0 {
+		p.log.Warn(fmt.Sprintf("Lost %d packets before packet %d of stream %08x (lost=%d, reordered=%d, duplicated=%d)", missing, sequence, stream.id, stream.tracker.Lost, stream.tracker.Reordered, stream.tracker.Duplicated))
+		p.conceal(stream, nextAt)
+	}
+
+	p.enqueue(samples, nextAt)
+	stream.last = samples
+	stream.lastNextAt = nextAt
+}
+
+func (p *Player) decode(stream *playerStream, msg proto.Message) ([][2]float64, error) {
+	switch m := msg.(type) {
+	case *message.StreamData:
+		return codec.DecodeStreamData(m)
+	case *message.EncodedStreamData:
+		decoder, found := stream.decoders[m.Codec]
+		if !found {
+			var err error
+			decoder, err = codec.NewDecoder(m.Codec, int(p.format.SampleRate))
+			if err != nil {
+				return nil, err
+			}
+			stream.decoders[m.Codec] = decoder
 		}
+
+		return decoder.Decode(m.Frame)
+	default:
+		return nil, fmt.Errorf("unexpected stream message %T", msg)
+	}
+}
+
+// forgetPayloads drop payloads too old to be used for recovery
+func (p *Player) forgetPayloads(stream *playerStream) {
+	if len(stream.payloads) <= 2*payloadHistory {
+		return
+	}
+
+	next, _ := stream.tracker.Next()
+	for sequence := range stream.payloads {
+		if sequence+payloadHistory < next {
+			delete(stream.payloads, sequence)
+		}
+	}
+}
+
+// conceal fill the gap left by lost packets by repeating last received samples while fading them out
+func (p *Player) conceal(stream *playerStream, until int64) {
+	if len(stream.last) == 0 {
+		return
+	}
+
+	from := stream.lastNextAt + int64(p.format.SampleRate.D(len(stream.last)))
+	gap := time.Duration(until - from)
+	if gap <= 0 || gap > maxConcealment {
+		return
+	}
+
+	concealed := make([][2]float64, p.format.SampleRate.N(gap))
+	for i := range concealed {
+		gain := 1 - float64(i)/float64(len(concealed))
+		sample := stream.last[i%len(stream.last)]
+		concealed[i] = [2]float64{sample[0] * gain, sample[1] * gain}
+	}
+
+	p.enqueue(concealed, from)
+}
+
+func (p *Player) enqueue(samples [][2]float64, nextAt int64) {
+	for index, sample := range samples {
+		p.tsq.Add(sample, nextAt+int64(p.format.SampleRate.D(index)*time.Nanosecond))
 	}
 }
 
@@ -69,14 +396,14 @@ func (p *Player) GetChan() chan proto.Message {
 func (p *Player) Stream(samples [][2]float64) (n int, ok bool) {
 	neededLength := len(samples)
 	silenceCount := 0
-	now := time.Now().UnixNano()
+	now := util.Now()
 
 	_, t := p.tsq.Peek()
 
 	for now-t > int64(10*time.Millisecond) {
 		// We are late, dropping samples
 		p.tsq.Remove()
-		now = time.Now().UnixNano()
+		now = util.Now()
 		_, t = p.tsq.Peek()
 	}
 
@@ -85,6 +412,9 @@ func (p *Player) Stream(samples [][2]float64) (n int, ok bool) {
 		p.log.Debug(fmt.Sprintf("Next packet is scheduled in %v", time.Duration(t-now)))
 		silenceCount = int(math.Min(float64(p.format.SampleRate.N(time.Duration(t-now))), float64(len(samples))))
 		p.silence.Stream(samples[:silenceCount])
+	} else {
+		// Smaller timing errors are slowly corrected by resampling
+		p.drift.observe(time.Duration(t - now))
 	}
 
 	for i := silenceCount; i < neededLength; i++ {
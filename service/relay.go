@@ -0,0 +1,87 @@
+package service
+
+import (
+	"fmt"
+	"github.com/tuarrep/sounddrop/message"
+	"github.com/tuarrep/sounddrop/structure"
+)
+
+// Number of senders for which relayed sequences are remembered
+const maxRelaySenders = 1024
+
+// relay forward audio and mesh state received from one network segment to peers of other segments.
+// Original envelope is kept so sender, sequence and timing are preserved, relayed packets are never relayed again
+func (srv *Server) relay(packet *message.Packet) {
+	if packet.Envelope.Flags&message.FlagRelayed != 0 {
+		return
+	}
+
+	reliable := false
+	switch packet.Message.(type) {
+	case *message.StreamData, *message.EncodedStreamData, *message.StreamParity:
+	case *message.DeviceStatus:
+		reliable = true
+	default:
+		return
+	}
+
+	// Packets relayed are not relayed again, so they come from the segment their sender was discovered on
+	sender, known := srv.peers[packet.Envelope.Sender]
+	if !known {
+		return
+	}
+	source := peerSegment(sender)
+
+	window, found := srv.relayWindows[packet.Envelope.Sender]
+	if !found {
+		if len(srv.relayWindows) >= maxRelaySenders {
+			srv.relayWindows = make(map[string]*structure.SlidingWindow)
+		}
+		window = &structure.SlidingWindow{}
+		srv.relayWindows[packet.Envelope.Sender] = window
+	}
+
+	// Same packet may be received twice, directly and from another relay
+	if !window.Check(packet.Envelope.Sequence) {
+		return
+	}
+
+	var targets []*Peer
+	for _, peer := range srv.peers {
+		if peer.id != packet.Envelope.Sender && peerSegment(peer) != source {
+			targets = append(targets, peer)
+		}
+	}
+
+	if len(targets) == 0 {
+		return
+	}
+
+	data, err := packet.Relay()
+	if err != nil {
+		srv.log.Warn("Unable to relay packet: ", err)
+		return
+	}
+
+	if reliable {
+		for _, peer := range targets {
+			srv.control.send(peer.id, peer.address, data)
+		}
+		return
+	}
+
+	var destinations []destination
+	for _, peer := range targets {
+		destinations = append(destinations, peer.destination())
+	}
+	srv.send(data, destinations)
+}
+
+// peerSegment network segment a peer was discovered on
+func peerSegment(peer *Peer) string {
+	if peer.ifIndex != 0 {
+		return fmt.Sprintf("if:%d", peer.ifIndex)
+	}
+
+	return "ip:" + peer.address.IP.String()
+}
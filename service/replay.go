@@ -0,0 +1,51 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tuarrep/sounddrop/message"
+	"github.com/tuarrep/sounddrop/structure"
+	"time"
+)
+
+// Largest difference between sending and reception time of an authenticated packet
+const maxPacketAge = 30 * time.Second
+
+// Number of senders for which received sequences are remembered
+const maxReplaySenders = 1024
+
+// errReplayed packet already received. Same packet may legitimately be received twice through relays, it is not rejected
+var errReplayed = errors.New("packet already received")
+
+// checkReplay tell whether an authenticated packet was already received, or sent too long ago to be checked.
+// Control frames have their own windows: while waiting for acknowledgement they can be overtaken by many datagrams
+func (srv *Server) checkReplay(envelope *message.Envelope, control bool) error {
+	if !message.Authenticated() {
+		return nil
+	}
+
+	age := time.Since(time.Unix(0, envelope.SentAt))
+	if age > maxPacketAge || age < -maxPacketAge {
+		return fmt.Errorf("packet sent %v away from our clock, more than %v: check clocks of devices", age.Round(time.Second), maxPacketAge)
+	}
+
+	windows := &srv.replayWindows
+	if control {
+		windows = &srv.controlWindows
+	}
+
+	window, found := (*windows)[envelope.Sender]
+	if !found {
+		if len(*windows) >= maxReplaySenders {
+			*windows = make(map[string]*structure.SlidingWindow)
+		}
+		window = &structure.SlidingWindow{}
+		(*windows)[envelope.Sender] = window
+	}
+
+	if !window.Check(envelope.Sequence) {
+		return errReplayed
+	}
+
+	return nil
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/tuarrep/sounddrop/codec"
+	"github.com/tuarrep/sounddrop/message"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+// Static RTP payload type of 44100Hz stereo L16 audio (RFC 3551)
+const rtpPayloadL16Stereo = 10
+
+// Dynamic RTP payload type used for L16 at other sample rates
+const rtpPayloadL16 = 97
+
+// Dynamic RTP payload type used for opus
+const rtpPayloadOpus = 96
+
+// Size of RTP header without extensions nor contributing sources
+const rtpHeaderSize = 12
+
+// Delay between two RTCP sender reports
+const rtcpInterval = 5 * time.Second
+
+// Seconds between NTP epoch (1900) and unix epoch (1970)
+const ntpEpochOffset = 2208988800
+
+// rtpSender copy the audio stream as standard RTP packets, with RTCP sender reports, for non Sounddrop receivers
+type rtpSender struct {
+	conn        *net.UDPConn
+	rtcpConn    *net.UDPConn
+	destination *net.UDPAddr
+	payloadType uint8
+	sampleRate  int
+	opus        bool
+	mtu         int
+	ssrc        uint32
+	cname       string
+	sequence    uint16
+	timestamp   uint32
+	packets     uint32
+	octets      uint32
+	lastReport  time.Time
+}
+
+// newRTPSender open RTP and RTCP (next port) sockets to address
+func newRTPSender(address string, sampleRate int, audioCodec message.AudioCodec, mtu int, cname string) (*rtpSender, error) {
+	destination, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP("udp", nil, destination)
+	if err != nil {
+		return nil, err
+	}
+
+	rtcpConn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: destination.IP, Port: destination.Port + 1, Zone: destination.Zone})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	sender := &rtpSender{
+		conn:        conn,
+		rtcpConn:    rtcpConn,
+		destination: destination,
+		sampleRate:  sampleRate,
+		mtu:         mtu,
+		ssrc:        newStreamID(),
+		cname:       cname,
+		sequence:    uint16(newStreamID()),
+		timestamp:   newStreamID(),
+	}
+
+	switch {
+	case audioCodec == message.AudioCodec_OPUS:
+		sender.payloadType = rtpPayloadOpus
+		sender.opus = true
+	case sampleRate == 44100:
+		sender.payloadType = rtpPayloadL16Stereo
+	default:
+		sender.payloadType = rtpPayloadL16
+	}
+
+	return sender, nil
+}
+
+// send packetize one stream frame. Opus frames are sent as is, other codecs are sent as L16
+func (r *rtpSender) send(samples [][2]float64, msg proto.Message, nextAt int64) error {
+	if m, ok := msg.(*message.EncodedStreamData); ok && r.opus {
+		first := r.timestamp
+		err := r.write(m.Frame, first)
+		// Opus RTP clock always runs at 48kHz, whatever the rate audio is played at
+		r.timestamp += uint32(len(samples) * 48000 / r.sampleRate)
+		r.report(nextAt, first)
+		return err
+	}
+
+	perPacket := (r.mtu - rtpHeaderSize) / codec.FrameSize(message.SampleFormat_INT16)
+	if perPacket <= 0 {
+		return fmt.Errorf("MTU %d is too small for RTP", r.mtu)
+	}
+
+	first := r.timestamp
+	for start := 0; start < len(samples); start += perPacket {
+		end := start + perPacket
+		if end > len(samples) {
+			end = len(samples)
+		}
+
+		if err := r.write(encodeL16(samples[start:end]), r.timestamp); err != nil {
+			return err
+		}
+		r.timestamp += uint32(end - start)
+	}
+
+	r.report(nextAt, first)
+	return nil
+}
+
+func (r *rtpSender) write(payload []byte, timestamp uint32) error {
+	packet := rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    r.payloadType,
+			SequenceNumber: r.sequence,
+			Timestamp:      timestamp,
+			SSRC:           r.ssrc,
+		},
+		Payload: payload,
+	}
+	r.sequence++
+
+	data, err := packet.Marshal()
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.conn.Write(data); err != nil {
+		return err
+	}
+
+	r.packets++
+	r.octets += uint32(len(payload))
+	return nil
+}
+
+// report send a RTCP sender report mapping RTP timestamp of a frame to its playing time, at most once per rtcpInterval
+func (r *rtpSender) report(nextAt int64, timestamp uint32) {
+	if time.Since(r.lastReport) < rtcpInterval {
+		return
+	}
+	r.lastReport = time.Now()
+
+	report := &rtcp.SenderReport{
+		SSRC:        r.ssrc,
+		NTPTime:     toNTPTime(nextAt),
+		RTPTime:     timestamp,
+		PacketCount: r.packets,
+		OctetCount:  r.octets,
+	}
+	description := &rtcp.SourceDescription{Chunks: []rtcp.SourceDescriptionChunk{{
+		Source: r.ssrc,
+		Items:  []rtcp.SourceDescriptionItem{{Type: rtcp.SDESCNAME, Text: r.cname}},
+	}}}
+
+	data, err := rtcp.Marshal([]rtcp.Packet{report, description})
+	if err == nil {
+		_, _ = r.rtcpConn.Write(data)
+	}
+}
+
+// writeSDP write the session description receivers need to play the RTP stream
+func (r *rtpSender) writeSDP(path string) error {
+	origin := r.conn.LocalAddr().(*net.UDPAddr).IP
+	addressType := "IP4"
+	connection := r.destination.IP.String()
+	if r.destination.IP.To4() == nil {
+		addressType = "IP6"
+	} else if r.destination.IP.IsMulticast() {
+		connection += "/1"
+	}
+
+	encoding := fmt.Sprintf("L16/%d/2", r.sampleRate)
+	format := ""
+	if r.opus {
+		encoding = "opus/48000/2"
+		// Receivers decode Opus as mono unless told it is stereo (RFC 7587)
+		format = fmt.Sprintf("a=fmtp:%d stereo=1; sprop-stereo=1\r\n", r.payloadType)
+	}
+
+	sdp := fmt.Sprintf("v=0\r\n"+
+		"o=- %d 0 IN %s %s\r\n"+
+		"s=Sounddrop\r\n"+
+		"c=IN %s %s\r\n"+
+		"t=0 0\r\n"+
+		"m=audio %d RTP/AVP %d\r\n"+
+		"a=rtpmap:%d %s\r\n%s",
+		r.ssrc, addressType, origin.String(), addressType, connection, r.destination.Port, r.payloadType, r.payloadType, encoding, format)
+
+	return ioutil.WriteFile(path, []byte(sdp), 0644)
+}
+
+// stop close RTP and RTCP sockets
+func (r *rtpSender) stop() {
+	r.conn.Close()
+	r.rtcpConn.Close()
+}
+
+// encodeL16 pack stereo samples as interleaved big endian 16 bits PCM (network order required by RFC 3551)
+func encodeL16(samples [][2]float64) []byte {
+	data, _ := codec.EncodePCM(samples, message.SampleFormat_INT16)
+	for i := 0; i+1 < len(data); i += 2 {
+		binary.BigEndian.PutUint16(data[i:], binary.LittleEndian.Uint16(data[i:]))
+	}
+
+	return data
+}
+
+// toNTPTime convert unix nanoseconds to 64 bits NTP timestamp
+func toNTPTime(unixNano int64) uint64 {
+	seconds := uint64(unixNano/int64(time.Second)) + ntpEpochOffset
+	fraction := uint64(unixNano%int64(time.Second)) << 32 / uint64(time.Second)
+
+	return seconds<<32 | fraction
+}
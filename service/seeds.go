@@ -0,0 +1,175 @@
+package service
+
+import (
+	"fmt"
+	"github.com/tuarrep/sounddrop/message"
+	"net"
+	"time"
+)
+
+// Delay between two peer list exchanges with seed peers
+const seedExchangeInterval = 10 * time.Second
+
+// Delay after which a peer learnt from a peer list, and not heard of since, is considered offline
+const peerListTimeout = 3 * seedExchangeInterval
+
+// Maximum number of peers accepted in one peer list
+const maxPeerList = 256
+
+// Maximum number of devices learnt from peer lists and announced to until they announce themselves
+const maxCandidates = 1024
+
+// candidate device learnt from a peer list. It is only announced to, it becomes a peer once it announces itself
+type candidate struct {
+	address   *net.UDPAddr
+	expiresAt time.Time
+}
+
+// unicastAnnounceAddresses addresses announces are sent to directly: static peers, seeds, peers not reachable by broadcast
+// and devices learnt from peer lists
+func (srv *Server) unicastAnnounceAddresses() []destination {
+	destinations := srv.resolvePeers(srv.sb.Config.Discover.Peers)
+	destinations = append(destinations, srv.resolvePeers(srv.sb.Config.Discover.Seeds)...)
+
+	for _, peer := range srv.peers {
+		if peer.ifIndex == 0 {
+			destinations = append(destinations, peer.destination())
+		}
+	}
+
+	for _, device := range srv.candidates {
+		destinations = append(destinations, destination{address: device.address})
+	}
+
+	// Static peers and seeds are also known peers once they answered
+	var unique []destination
+	seen := make(map[string]bool)
+	for _, to := range destinations {
+		if !seen[to.address.String()] {
+			seen[to.address.String()] = true
+			unique = append(unique, to)
+		}
+	}
+
+	return unique
+}
+
+// exchangePeerLists send known peers to each seed, asking for theirs in return
+func (srv *Server) exchangePeerLists() {
+	if len(srv.sb.Config.Discover.Seeds) == 0 || time.Since(srv.lastExchange) < seedExchangeInterval {
+		return
+	}
+	srv.lastExchange = time.Now()
+
+	data, err := message.ToBuffer(srv.peerList(true))
+	if err != nil {
+		srv.log.Warn("Unable to build peer list: ", err)
+		return
+	}
+
+	srv.seedAddresses = srv.resolvePeers(srv.sb.Config.Discover.Seeds)
+	for _, to := range srv.seedAddresses {
+		if err := srv.writeTo(data, to); err != nil {
+			srv.log.Debug(fmt.Sprintf("Unable to send peer list to seed %s: %v", to.address, err))
+		}
+	}
+}
+
+// peerList list of known peers. Link-local addresses only make sense on their link, they are left out
+func (srv *Server) peerList(request bool) *message.PeerList {
+	list := &message.PeerList{Request: request}
+
+	for _, peer := range srv.peers {
+		if peer.address.Zone != "" || peer.address.IP.IsLinkLocalUnicast() {
+			continue
+		}
+
+		list.Peers = append(list.Peers, &message.PeerInfo{Id: peer.id, Address: peer.address.String(), MulticastGroup: peer.multicastGroup})
+		if len(list.Peers) >= maxPeerList {
+			break
+		}
+	}
+
+	return list
+}
+
+// handlePeerList remember devices unknown so far to announce to them directly, they become peers once they announce themselves.
+// Lists are only taken from seeds and identified peers. Answer with our own list when asked
+func (srv *Server) handlePeerList(m *message.PeerList, sender string, addr *net.UDPAddr) {
+	if peer, found := srv.peers[sender]; (!found || peer.publicKey == nil) && !srv.isSeed(addr) {
+		srv.reject(addr, fmt.Errorf("peer list from %s, neither a seed nor an identified peer", sender))
+		return
+	}
+
+	for _, info := range m.Peers {
+		if info.Id == srv.sb.DeviceID.String() {
+			continue
+		}
+
+		if _, found := srv.peers[info.Id]; found {
+			continue
+		}
+
+		if _, found := srv.candidates[info.Id]; !found && len(srv.candidates) >= maxCandidates {
+			continue
+		}
+
+		// Addresses are IP literals, checked when packet was validated
+		peerAddr, err := net.ResolveUDPAddr("udp", info.Address)
+		if err != nil {
+			continue
+		}
+
+		srv.candidates[info.Id] = candidate{address: peerAddr, expiresAt: time.Now().Add(peerListTimeout)}
+	}
+
+	if !m.Request {
+		return
+	}
+
+	data, err := message.ToBuffer(srv.peerList(false))
+	if err != nil {
+		srv.log.Warn("Unable to build peer list: ", err)
+		return
+	}
+
+	if err := srv.writeTo(data, destination{address: addr}); err != nil {
+		srv.log.Debug(fmt.Sprintf("Unable to answer peer list to %s: %v", addr, err))
+	}
+}
+
+// isSeed whether address is the one of a seed, as resolved at last peer list exchange
+func (srv *Server) isSeed(addr *net.UDPAddr) bool {
+	for _, seed := range srv.seedAddresses {
+		if seed.address.IP.Equal(addr.IP) && seed.address.Port == addr.Port {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forgetCandidates drop devices learnt from peer lists which were not listed again in time
+func (srv *Server) forgetCandidates() {
+	for id, device := range srv.candidates {
+		if time.Now().After(device.expiresAt) {
+			delete(srv.candidates, id)
+		}
+	}
+}
+
+// resolvePeers resolve host:port peer addresses, skipping invalid ones
+func (srv *Server) resolvePeers(addresses []string) []destination {
+	var destinations []destination
+
+	for _, address := range addresses {
+		addr, err := net.ResolveUDPAddr("udp", address)
+		if err != nil {
+			srv.log.Debug(fmt.Sprintf("Unable to resolve peer %s: %v", address, err))
+			continue
+		}
+		destinations = append(destinations, destination{address: addr})
+	}
+
+	return destinations
+}
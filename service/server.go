@@ -1,38 +1,107 @@
 package service
 
 import (
+	"crypto/ed25519"
+	"errors"
 	"fmt"
 	"github.com/golang/protobuf/proto"
 	"github.com/sirupsen/logrus"
 	"github.com/tuarrep/sounddrop/message"
+	"github.com/tuarrep/sounddrop/structure"
 	"github.com/tuarrep/sounddrop/util"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 	"net"
+	"sync"
 	"time"
 )
 
 //Peer structure represents discovered peer on mesh network
 type Peer struct {
-	id       string
-	address  *net.UDPAddr
-	lastSeen time.Time
+	id             string
+	address        *net.UDPAddr
+	lastSeen       time.Time
+	expiresAt      time.Time
+	multicastGroup string
+	ifIndex        int
+	publicKey      ed25519.PublicKey
 }
 
+// destination where to send datagrams to reach peer
+func (peer *Peer) destination() destination {
+	return destination{address: peer.address, ifIndex: peer.ifIndex, peer: peer.id}
+}
+
+// received packet read from network or control channel, waiting for server to handle it
+type received struct {
+	data    []byte
+	addr    *net.UDPAddr
+	ifIndex int
+	control bool
+}
+
+// packetOrigin how a packet reached server
+type packetOrigin int
+
+const (
+	fromNetwork packetOrigin = iota
+	fromReassembly
+	fromDecryption
+)
+
 // Server UDP server service
 type Server struct {
 	message   chan proto.Message
 	ticker    chan bool
+	inbox     chan received
 	Messenger *Messenger
 	log       *logrus.Entry
 	sc        *net.UDPConn
 	sb        *util.ServiceBag
 	peers     map[string]*Peer
+
+	multicastGroup *net.UDPAddr
+	fragmentID     uint32
+	reassembler    reassembler
+	rejected       map[string]uint64
+	control        *controlChannel
+
+	notifications      []proto.Message
+	notificationsMutex sync.Mutex
+	notificationsWake  chan bool
+
+	pc4                *ipv4.PacketConn
+	pc6                *ipv6.PacketConn
+	ingressIPv6        bool
+	interfaces         []net.Interface
+	interfaceTable     []interfaceNetworks
+	announceInterfaces []net.Interface
+	lastExchange       time.Time
+	seedAddresses      []destination
+	candidates         map[string]candidate
+	relayWindows       map[string]*structure.SlidingWindow
+	replayWindows      map[string]*structure.SlidingWindow
+	controlWindows     map[string]*structure.SlidingWindow
+	encryption         *encryption
+	trusted            map[string]bool
+	selfAccepted       bool
 }
 
 var tickInterval = 1 * time.Second
 
+// Number of source addresses for which rejected packets are counted
+const maxRejectedSources = 1024
+
+// Number of received packets waiting for server to handle them
+const inboxSize = 256
+
+// Number of messages waiting to be passed to messenger, more are dropped
+const maxNotifications = 4096
+
 // Stop clean service when stopped by supervisor
 func (srv *Server) Stop() {
 	srv.sc.Close()
+	srv.control.stop()
 	srv.log.Info("Server stopped.")
 }
 
@@ -43,6 +112,7 @@ func (srv *Server) Serve() {
 	srv.log.Info("Server starting...")
 	srv.sb = util.GetServiceBag()
 
+	// Unspecified address gives a dual-stack socket receiving both IPv4 and IPv6
 	ServerAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%v", srv.sb.Config.Discover.Port))
 	util.CheckError(err, srv.log)
 	srv.sc, err = net.ListenUDP("udp", ServerAddr)
@@ -50,22 +120,54 @@ func (srv *Server) Serve() {
 
 	srv.message = make(chan proto.Message)
 	srv.ticker = make(chan bool)
+	srv.inbox = make(chan received, inboxSize)
+	srv.notificationsWake = make(chan bool, 1)
 	srv.peers = make(map[string]*Peer)
+	srv.candidates = make(map[string]candidate)
+	srv.trusted = make(map[string]bool)
+	srv.rejected = make(map[string]uint64)
+	srv.relayWindows = make(map[string]*structure.SlidingWindow)
+	srv.replayWindows = make(map[string]*structure.SlidingWindow)
+	srv.controlWindows = make(map[string]*structure.SlidingWindow)
+
+	srv.pc4 = ipv4.NewPacketConn(srv.sc)
+	srv.pc6 = ipv6.NewPacketConn(srv.sc)
+	srv.enableIngressInterface()
+	srv.selectInterfaces()
+	srv.refreshInterfaces()
+	srv.startEncryption()
+	if srv.encryption == nil {
+		srv.joinMulticastGroup()
+	}
+	srv.joinAnnounceGroup()
 
+	var wrap func(peer string, data []byte) ([]byte, error)
+	if srv.encryption != nil {
+		wrap = srv.encryption.seal
+	}
+	srv.control, err = newControlChannel(srv.sb.Config.Discover.Port, srv.receiveControl, wrap, srv.log)
+	util.CheckError(err, srv.log)
+
+	go srv.notifyLoop()
 	go srv.listenerLoop()
 	go srv.tick()
 
-	srv.Messenger.Register(message.WriteRequestMessage, srv)
+	srv.Messenger.RegisterSome([]byte{message.WriteRequestMessage, message.PeerDiscoveredMessage, message.AcceptedDevicesMessage}, srv)
 
 	srv.log.Info("Server started. Listening at", srv.sc.LocalAddr().String())
 
 	for {
 		select {
 		case _ = <-srv.ticker:
+			srv.refreshInterfaces()
 			srv.sendAnnounce()
+			srv.exchangePeerLists()
+			srv.maintainEncryption()
 			srv.checkPeersHealth()
 		case msg := <-srv.message:
 			srv.handleMessages(msg)
+		case in := <-srv.inbox:
+			srv.handlePacket(in.data, in.addr, in.ifIndex, fromNetwork, in.control)
 		}
 	}
 }
@@ -73,26 +175,107 @@ func (srv *Server) Serve() {
 func (srv *Server) handleMessages(msg proto.Message) {
 	switch m := msg.(type) {
 	case *message.WriteRequest:
-		var addresses []*net.UDPAddr
+		if m.Reliable {
+			srv.sendReliable(m)
+			return
+		}
+
+		var destinations []destination
 
-		if m.DeviceName == "*" {
+		// Encrypted messages are sealed for each peer, they are never multicast
+		if m.DeviceName == "*" && m.Multicast && srv.multicastGroup != nil && srv.encryption == nil {
+			destinations = srv.multicastAddresses()
+		} else if m.DeviceName == "*" {
 			for _, peer := range srv.peers {
-				addresses = append(addresses, peer.address)
+				destinations = append(destinations, peer.destination())
 			}
 		} else if peer, exists := srv.peers[m.DeviceName]; exists {
-			addresses = append(addresses, peer.address)
+			destinations = append(destinations, peer.destination())
+		}
+
+		srv.send(m.Message, destinations)
+	case *message.PeerDiscovered:
+		srv.handlePeerDiscovered(m)
+	case *message.AcceptedDevices:
+		// Devices being paired need keys too, pairing messages are encrypted
+		for _, id := range append(m.Devices, m.Pairing...) {
+			srv.trusted[id] = true
+		}
+		srv.selfAccepted = srv.selfAccepted || m.Accepted
+	}
+}
+
+// handlePeerDiscovered record a peer found by another discovery backend than announces
+func (srv *Server) handlePeerDiscovered(m *message.PeerDiscovered) {
+	if m.Id == srv.sb.DeviceID.String() {
+		return
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", m.Address)
+	if err != nil {
+		srv.log.Warn(fmt.Sprintf("Invalid address %s for device %s", m.Address, m.Id))
+		return
+	}
+
+	ifIndex := 0
+	if iface := srv.interfaceFor(addr); iface != nil {
+		ifIndex = iface.Index
+	}
+
+	// Identified devices keep the address they sign announces from
+	if peer, found := srv.peers[m.Id]; found && peer.publicKey != nil {
+		return
+	}
+
+	if srv.updatePeer(m.Id, addr, m.MulticastGroup, ifIndex, dnssdPeerTimeout) {
+		srv.notify(&message.PeerOnline{Id: m.Id})
+	}
+}
+
+// send message to destinations, encrypting it for each peer when enabled and fragmenting it when larger than MTU
+func (srv *Server) send(data []byte, destinations []destination) {
+	for _, to := range destinations {
+		payload := data
+		if srv.encryption != nil {
+			var err error
+			payload, err = srv.encryption.seal(to.peer, data)
+			if err != nil {
+				srv.log.Debug(fmt.Sprintf("Not sending message to %s: %v", to.address, err))
+				continue
+			}
+		}
+
+		datagrams := [][]byte{payload}
+		if len(payload) > srv.sb.Config.Discover.MTU {
+			var err error
+			datagrams, err = srv.fragment(payload)
+			if err != nil {
+				srv.log.Warn(fmt.Sprintf("Failed to send message of %d bytes due to %v", len(payload), err.Error()))
+				continue
+			}
 		}
 
-		for _, address := range addresses {
-			_, err := srv.sc.WriteToUDP(m.Message, address)
+		for _, datagram := range datagrams {
+			err := srv.writeTo(datagram, to)
 
 			if err != nil {
-				srv.log.Warn(fmt.Sprintf("Failed to send message %d due to %v", m.Message[0], err.Error()))
+				srv.log.Warn(fmt.Sprintf("Failed to send message of %d bytes due to %v", len(payload), err.Error()))
 			}
 		}
 	}
 }
 
+// sendReliable queue message on control channel of each targeted peer
+func (srv *Server) sendReliable(m *message.WriteRequest) {
+	if m.DeviceName == "*" {
+		for _, peer := range srv.peers {
+			srv.control.send(peer.id, peer.address, m.Message)
+		}
+	} else if peer, exists := srv.peers[m.DeviceName]; exists {
+		srv.control.send(peer.id, peer.address, m.Message)
+	}
+}
+
 // GetChan returns messaging chan
 func (srv *Server) GetChan() chan proto.Message {
 	return srv.message
@@ -105,54 +288,247 @@ func (srv *Server) tick() {
 	}
 }
 
+// listenerLoop read datagrams from network and pass them to server goroutine, the only one touching peers
 func (srv *Server) listenerLoop() {
 	buf := make([]byte, 65526)
 
 	for {
-		n, addr, err := srv.sc.ReadFromUDP(buf)
-		msg, err := message.FromBuffer(buf[0:n])
-		util.CheckError(err, srv.log)
-		switch m := msg.(type) {
-		case *message.Announce:
-			if m.DeviceName == srv.sb.DeviceID.String() {
-				continue
-			}
+		n, addr, ifIndex, err := srv.readFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			// Socket is closed when server is stopped or restarted
+			return
+		} else if err != nil {
+			srv.log.Warn("Unable to read from network: ", err)
+			continue
+		}
 
-			if m.ServiceNumber != message.ServiceNumber {
-				continue
-			}
+		data := make([]byte, n)
+		copy(data, buf[0:n])
+		srv.receive(data, addr, ifIndex)
+	}
+}
 
-			if _, found := srv.peers[m.DeviceName]; found {
-				srv.peers[m.DeviceName].address = addr
-				srv.peers[m.DeviceName].lastSeen = time.Now()
-			} else {
-				srv.log.Debug("New device discovered: ", m.DeviceName)
-				srv.peers[m.DeviceName] = &Peer{id: m.DeviceName, address: addr, lastSeen: time.Now()}
+// receive queue a packet received on UDP socket for server goroutine
+func (srv *Server) receive(data []byte, addr *net.UDPAddr, ifIndex int) {
+	srv.inbox <- received{data: data, addr: addr, ifIndex: ifIndex}
+}
 
-				notification := &message.PeerOnline{Id: m.DeviceName}
-				srv.Messenger.Message <- notification
-			}
-		default:
+// receiveControl queue a packet received on control channel for server goroutine
+func (srv *Server) receiveControl(data []byte, addr *net.UDPAddr) {
+	srv.inbox <- received{data: data, addr: addr, control: true}
+}
+
+// notify pass a message to messenger without blocking server goroutine, messenger may be waiting for it to read
+// next message. Messages keep their order
+func (srv *Server) notify(msg proto.Message) {
+	srv.notificationsMutex.Lock()
+	if len(srv.notifications) >= maxNotifications {
+		srv.notificationsMutex.Unlock()
+		srv.log.Warn(fmt.Sprintf("Messenger is not keeping up, dropping %T message", msg))
+		return
+	}
+	srv.notifications = append(srv.notifications, msg)
+	srv.notificationsMutex.Unlock()
+
+	select {
+	case srv.notificationsWake <- true:
+	default:
+	}
+}
+
+// notifyLoop send messages queued by notify to messenger
+func (srv *Server) notifyLoop() {
+	for range srv.notificationsWake {
+		srv.notificationsMutex.Lock()
+		pending := srv.notifications
+		srv.notifications = nil
+		srv.notificationsMutex.Unlock()
+
+		for _, msg := range pending {
 			srv.Messenger.Message <- msg
 		}
 	}
 }
 
+// handlePacket decode, check and dispatch a packet. Reassembled messages are not checked for replay, their fragments were.
+// Packets of control channel, decrypted ones included, are checked for replay apart from datagrams
+func (srv *Server) handlePacket(data []byte, addr *net.UDPAddr, ifIndex int, origin packetOrigin, control bool) {
+	packet, err := message.FromBuffer(data)
+	if err == nil {
+		err = validatePacket(packet)
+	}
+	if err != nil {
+		srv.reject(addr, err)
+		return
+	}
+
+	if packet.Envelope.Sender == srv.sb.DeviceID.String() {
+		return
+	}
+
+	// Devices of other meshes share the network, ignore their traffic
+	if packet.Envelope.MeshId != srv.sb.Config.Mesh.ID {
+		return
+	}
+
+	if origin != fromReassembly {
+		if err := srv.checkReplay(packet.Envelope, control); err == errReplayed {
+			srv.log.Debug(fmt.Sprintf("Dropping replayed packet %d from %s", packet.Envelope.Sequence, packet.Envelope.Sender))
+			return
+		} else if err != nil {
+			srv.reject(addr, err)
+			return
+		}
+	}
+
+	switch m := packet.Message.(type) {
+	case *message.Fragment:
+		whole, err := srv.reassembler.add(addr.String(), m)
+		if err != nil {
+			srv.reject(addr, err)
+			return
+		}
+
+		if whole != nil {
+			srv.handlePacket(whole, addr, ifIndex, fromReassembly, control)
+		}
+	case *message.Announce:
+		if m.DeviceName == srv.sb.DeviceID.String() {
+			return
+		}
+
+		if m.ServiceNumber != message.ServiceNumber || m.MeshId != srv.sb.Config.Mesh.ID {
+			return
+		}
+
+		if !srv.isSelectedIndex(ifIndex) {
+			return
+		}
+
+		// Anyone can claim a device ID, only its key owner can sign announces for it
+		if m.DeviceName != packet.Envelope.Sender {
+			srv.reject(addr, fmt.Errorf("announce of %s sent by %s", m.DeviceName, packet.Envelope.Sender))
+			return
+		}
+		if err := verifyAnnounce(m); err != nil {
+			srv.reject(addr, err)
+			return
+		}
+
+		isNew := srv.updatePeer(m.DeviceName, addr, m.MulticastGroup, srv.onLinkIndex(addr, ifIndex), 3*tickInterval)
+		delete(srv.candidates, m.DeviceName)
+
+		// Devices found through DNS-SD are only identified once they announce themselves
+		peer := srv.peers[m.DeviceName]
+		wasAnonymous := peer.publicKey == nil
+		peer.publicKey = m.PublicKey
+
+		if isNew || wasAnonymous {
+			srv.notify(&message.PeerOnline{Id: m.DeviceName, Fingerprint: util.Fingerprint(m.PublicKey)})
+		}
+	case *message.PeerList:
+		srv.handlePeerList(m, packet.Envelope.Sender, addr)
+	case *message.Handshake:
+		srv.handleHandshake(m, packet.Envelope.Sender, addr)
+	case *message.Sealed:
+		if srv.encryption == nil {
+			return
+		}
+
+		plaintext, err := srv.encryption.open(m, packet.Envelope.Sender)
+		if err == errUnknownSession {
+			srv.restartEncryption(packet.Envelope.Sender)
+		}
+		if err != nil {
+			srv.reject(addr, err)
+			return
+		}
+
+		srv.handlePacket(plaintext, addr, ifIndex, fromDecryption, control)
+	default:
+		if srv.encryption != nil && origin != fromDecryption {
+			srv.reject(addr, fmt.Errorf("unencrypted %T message", packet.Message))
+			return
+		}
+
+		if srv.sb.Config.Discover.Relay {
+			srv.relay(packet)
+		}
+
+		srv.notify(packet)
+	}
+}
+
+// updatePeer record a peer seen at address, considering it online for at least timeout. Returns whether peer is new
+func (srv *Server) updatePeer(id string, addr *net.UDPAddr, multicastGroup string, ifIndex int, timeout time.Duration) bool {
+	expiresAt := time.Now().Add(timeout)
+
+	if peer, found := srv.peers[id]; found {
+		peer.address = addr
+		peer.lastSeen = time.Now()
+		peer.multicastGroup = multicastGroup
+		peer.ifIndex = ifIndex
+		if expiresAt.After(peer.expiresAt) {
+			peer.expiresAt = expiresAt
+		}
+		return false
+	}
+
+	srv.log.Debug("New device discovered: ", id)
+	srv.peers[id] = &Peer{id: id, address: addr, lastSeen: time.Now(), expiresAt: expiresAt, multicastGroup: multicastGroup, ifIndex: ifIndex}
+
+	return true
+}
+
+// reject count a packet dropped because it is malformed, logging only some of them so a flood does not fill logs
+func (srv *Server) reject(addr *net.UDPAddr, err error) {
+	source := addr.IP.String()
+	if _, found := srv.rejected[source]; !found && len(srv.rejected) >= maxRejectedSources {
+		srv.rejected = make(map[string]uint64)
+	}
+	srv.rejected[source]++
+
+	count := srv.rejected[source]
+	if count == 1 || count%100 == 0 {
+		srv.log.Warn(fmt.Sprintf("Rejected packet from %s (%d rejected so far): %v", source, count, err))
+	}
+}
+
 func (srv *Server) sendAnnounce() {
-	announce := &message.Announce{ServiceNumber: message.ServiceNumber, DeviceName: srv.sb.DeviceID.String()}
+	announce := &message.Announce{ServiceNumber: message.ServiceNumber, DeviceName: srv.sb.DeviceID.String(), MeshId: srv.sb.Config.Mesh.ID}
+	if srv.multicastGroup != nil {
+		announce.MulticastGroup = srv.multicastGroup.IP.String()
+	}
+	srv.signAnnounce(announce)
 	data, err := message.ToBuffer(announce)
 	util.CheckError(err, srv.log)
-	_, err = srv.sc.WriteToUDP(data, &net.UDPAddr{IP: net.IP{255, 255, 255, 255}, Port: srv.sb.Config.Discover.Port})
-	util.CheckError(err, srv.log)
+
+	sent := 0
+	for _, to := range srv.announceAddresses() {
+		if err := srv.writeTo(data, to); err != nil {
+			srv.log.Debug(fmt.Sprintf("Unable to announce to %s: %v", to.address, err))
+			continue
+		}
+		sent++
+	}
+
+	if sent == 0 {
+		srv.log.Warn("Unable to send announce on any network")
+	}
 }
 
 func (srv *Server) checkPeersHealth() {
 	for id, device := range srv.peers {
-		if time.Now().After(device.lastSeen.Add(3 * tickInterval)) {
+		if time.Now().After(device.expiresAt) {
 			srv.log.Warn(fmt.Sprintf("Device %s not announced since a while. Romoving it from known peers.", id))
 			delete(srv.peers, id)
-			notification := &message.PeerOffline{Id: id}
-			srv.Messenger.Message <- notification
+			if srv.encryption != nil {
+				srv.encryption.forget(id)
+			}
+			srv.control.forget(id)
+			srv.notify(&message.PeerOffline{Id: id})
 		}
 	}
+
+	srv.forgetCandidates()
 }
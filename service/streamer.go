@@ -1,6 +1,8 @@
 package service
 
 import (
+	"crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"github.com/faiface/beep"
 	"github.com/faiface/beep/mp3"
@@ -8,7 +10,9 @@ import (
 	"github.com/golang/protobuf/proto"
 	"github.com/h2non/filetype"
 	"github.com/sirupsen/logrus"
+	"github.com/tuarrep/sounddrop/codec"
 	"github.com/tuarrep/sounddrop/message"
+	"github.com/tuarrep/sounddrop/structure"
 	"github.com/tuarrep/sounddrop/util"
 	"io/ioutil"
 	"os"
@@ -17,15 +21,52 @@ import (
 
 // Streamer audio streamer service
 type Streamer struct {
-	Message   chan proto.Message
-	log       *logrus.Entry
-	Messenger *Messenger
-	sb        *util.ServiceBag
-	stream    beep.Streamer
+	Message      chan proto.Message
+	log          *logrus.Entry
+	Messenger    *Messenger
+	sb           *util.ServiceBag
+	stream       beep.Streamer
+	sampleFormat message.SampleFormat
+	audioCodec   message.AudioCodec
+	encoder      codec.Encoder
+	streamID     uint32
+	sequence     uint64
+	protected    []proto.Message
+	sent         *structure.PacketRing
+	retransmits  chan retransmission
+	lastNacks    map[string]time.Time
+	rtp          *rtpSender
 }
 
+// retransmission packets requested by a player
+type retransmission struct {
+	target string
+	nack   *message.StreamNack
+}
+
+// Number of sent packets kept to be retransmitted on players request
+const retransmitHistory = 1024
+
+// Maximum number of packets retransmitted for one request
+const maxRetransmit = 64
+
+// Minimum delay between two retransmissions to the same player
+const retransmitInterval = 100 * time.Millisecond
+
+// Maximum number of players whose last retransmission request is remembered
+const maxNackSenders = 256
+
+// Number of retransmission requests waiting to be served, others are ignored
+const retransmitQueue = 16
+
+// Delay between sending audio and playing it, leaving players time to receive and buffer it
+const streamLead = 5 * time.Second
+
 // Stop clean service when stopped by supervisor
 func (s *Streamer) Stop() {
+	if s.rtp != nil {
+		s.rtp.stop()
+	}
 	s.log.Info("Streamer stopped.")
 }
 
@@ -35,9 +76,37 @@ func (s *Streamer) Serve() {
 	s.log.Info("Streamer starting...")
 
 	s.sb = util.GetServiceBag()
+	s.streamID = newStreamID()
+	s.sent = structure.NewPacketRing(retransmitHistory)
+
+	s.retransmits = make(chan retransmission, retransmitQueue)
+	s.lastNacks = make(map[string]time.Time)
+	go s.retransmitLoop()
+
+	s.Message = make(chan proto.Message)
+	s.Messenger.Register(message.StreamNackMessage, s)
+	go s.handleMessages()
 
 	targetSampleRate := beep.SampleRate(s.sb.Config.Streamer.ResamplingRate)
 
+	sampleFormat, err := codec.ParseSampleFormat(s.sb.Config.Streamer.SampleFormat)
+	util.CheckError(err, s.log)
+	s.sampleFormat = sampleFormat
+
+	audioCodec, err := codec.ParseCodec(s.sb.Config.Streamer.Codec)
+	util.CheckError(err, s.log)
+	s.audioCodec = audioCodec
+
+	if audioCodec != message.AudioCodec_NO_CODEC {
+		options := codec.EncoderOptions{SampleRate: int(targetSampleRate), SampleFormat: sampleFormat, Bitrate: s.sb.Config.Streamer.OpusBitrate}
+		s.encoder, err = codec.NewEncoder(audioCodec, options)
+		util.CheckError(err, s.log)
+	}
+
+	if s.sb.Config.Streamer.RTPAddress != "" {
+		s.startRTP(int(targetSampleRate))
+	}
+
 	files, err := ioutil.ReadDir(s.sb.Config.Streamer.PlaylistDir)
 	util.CheckError(err, s.log)
 
@@ -100,34 +169,219 @@ func (s *Streamer) getStream(file os.FileInfo) (beep.StreamCloser, beep.Format,
 }
 
 func (s *Streamer) streamToMessage(stream streamable, sampleRate beep.SampleRate) {
-	buff := make([][2]float64, 512)
-	ok := true
-	n := 512
-	nextRunAt := time.Now().UnixNano() + 5*time.Second.Nanoseconds()
+	frameSize := 512
+	if s.encoder != nil {
+		frameSize = s.encoder.FrameSize()
+	}
 
-	for ok == true {
-		now := time.Now().UnixNano()
+	buff := make([][2]float64, frameSize)
+	ok := true
 
-		n, ok = stream.Stream(buff)
+	// Frame times are computed from a fixed timeline, so rounding errors and oversleeping do not add up
+	start := util.Now() + streamLead.Nanoseconds()
+	var scheduled int64
 
-		samplesLeft := make([]float64, n)
-		samplesRight := make([]float64, n)
+	for ok == true {
+		// Codecs work on fixed size frames, fill the whole buffer unless stream ended
+		n := 0
+		for n < frameSize && ok {
+			var read int
+			read, ok = stream.Stream(buff[n:])
+			n += read
+		}
 
-		for i := 0; i < n; i++ {
-			samplesLeft[i] = buff[i][0]
-			samplesRight[i] = buff[i][1]
+		if n == 0 {
+			break
 		}
 
-		nextRunIn := sampleRate.D(n)
-		nextRunAt += nextRunIn.Nanoseconds()
+		scheduled += int64(n)
+		nextAt := start + timelineOffset(sampleRate, scheduled).Nanoseconds()
 
-		msg := &message.StreamData{SamplesLeft: samplesLeft, SamplesRight: samplesRight, NextAt: nextRunAt}
+		msg, err := s.encode(buff[:n], nextAt)
+		util.CheckError(err, s.log)
 		s.Messenger.Message <- msg
 		msgData, _ := message.ToBuffer(msg)
-		s.Messenger.Message <- &message.WriteRequest{DeviceName: "*", Message: msgData}
+		s.sent.Put(s.sequence, msgData)
+		s.Messenger.Message <- &message.WriteRequest{DeviceName: "*", Message: msgData, Multicast: true}
+		s.protect(msg)
+		s.sequence++
+
+		if s.rtp != nil {
+			if err := s.rtp.send(buff[:n], msg, nextAt); err != nil {
+				s.log.Warn("Failed to send RTP packet: ", err)
+			}
+		}
+
+		// Next frame is sent one lead ahead of its playing time
+		wait := time.Duration(nextAt - streamLead.Nanoseconds() - util.Now())
+		if wait > 0 {
+			time.Sleep(wait)
+		} else if -wait > streamLead {
+			// Players already missed next frames, start timeline again from now
+			s.log.Warn(fmt.Sprintf("Streamer is late by %v, delaying stream", -wait))
+			start += (-wait).Nanoseconds()
+		}
+	}
+}
+
+// timelineOffset duration of a number of samples from timeline start, without overflowing on long streams
+func timelineOffset(sampleRate beep.SampleRate, samples int64) time.Duration {
+	rate := int64(sampleRate)
 
-		time.Sleep(nextRunIn - time.Duration(time.Now().UnixNano()-now) - time.Millisecond)
+	return time.Duration(samples/rate)*time.Second + sampleRate.D(int(samples%rate))
+}
+
+// startRTP open the RTP output and write its SDP description
+func (s *Streamer) startRTP(sampleRate int) {
+	var err error
+	s.rtp, err = newRTPSender(s.sb.Config.Streamer.RTPAddress, sampleRate, s.audioCodec, s.sb.Config.Discover.MTU, s.sb.DeviceID.String())
+	util.CheckError(err, s.log)
+
+	if s.sb.Config.Streamer.RTPSDP != "" {
+		err = s.rtp.writeSDP(s.sb.Config.Streamer.RTPSDP)
+		util.CheckError(err, s.log)
 	}
+
+	s.log.Info("Sending RTP stream to ", s.sb.Config.Streamer.RTPAddress)
+}
+
+func (s *Streamer) encode(samples [][2]float64, nextAt int64) (proto.Message, error) {
+	if s.encoder == nil {
+		msg := &message.StreamData{NextAt: nextAt, StreamId: s.streamID, Sequence: s.sequence}
+		err := codec.EncodeStreamData(msg, samples, s.sampleFormat)
+		return msg, err
+	}
+
+	frame, err := s.encoder.Encode(samples)
+	if err != nil {
+		return nil, err
+	}
+
+	return &message.EncodedStreamData{Codec: s.audioCodec, Frame: frame, NextAt: nextAt, StreamId: s.streamID, Sequence: s.sequence}, nil
+}
+
+// rememberNack record when sender last requested a retransmission, forgetting requests older than retransmitInterval
+// when too many are remembered. Returns false if there is still no room for sender
+func (s *Streamer) rememberNack(sender string) bool {
+	if _, found := s.lastNacks[sender]; !found && len(s.lastNacks) >= maxNackSenders {
+		for peer, at := range s.lastNacks {
+			if time.Since(at) >= retransmitInterval {
+				delete(s.lastNacks, peer)
+			}
+		}
+
+		if len(s.lastNacks) >= maxNackSenders {
+			return false
+		}
+	}
+
+	s.lastNacks[sender] = time.Now()
+	return true
+}
+
+// GetChan returns messaging chan
+func (s *Streamer) GetChan() chan proto.Message {
+	return s.Message
+}
+
+func (s *Streamer) handleMessages() {
+	for packet := range s.Message {
+		msg, envelope := message.Unwrap(packet)
+		switch m := msg.(type) {
+		case *message.StreamNack:
+			if m.StreamId != s.streamID || envelope == nil {
+				continue
+			}
+
+			if time.Since(s.lastNacks[envelope.Sender]) < retransmitInterval {
+				continue
+			}
+			if !s.rememberNack(envelope.Sender) {
+				s.log.Debug("Too many players requesting retransmissions, ignoring one from ", envelope.Sender)
+				continue
+			}
+
+			// Messenger may be waiting for us to read next message, do not block it
+			select {
+			case s.retransmits <- retransmission{target: envelope.Sender, nack: m}:
+			default:
+				s.log.Debug("Too many retransmission requests, ignoring one from ", envelope.Sender)
+			}
+		}
+	}
+}
+
+func (s *Streamer) retransmitLoop() {
+	for r := range s.retransmits {
+		s.retransmit(r.target, r.nack)
+	}
+}
+
+// retransmit send again packets reported missing by a player to this player only
+func (s *Streamer) retransmit(target string, nack *message.StreamNack) {
+	first, last, ok := s.sent.Span()
+	if !ok {
+		return
+	}
+
+	count := 0
+	for _, missing := range nack.Missing {
+		// Only packets still kept can be sent again
+		from, to := missing.First, missing.Last
+		if from < first {
+			from = first
+		}
+		if to > last {
+			to = last
+		}
+
+		for sequence := from; sequence <= to && count < maxRetransmit; sequence++ {
+			if data, found := s.sent.Get(sequence); found {
+				// Receiver would take the very same packet for a replay
+				data, err := message.Restamp(data)
+				if err != nil {
+					continue
+				}
+
+				s.Messenger.Message <- &message.WriteRequest{DeviceName: target, Message: data}
+				count++
+			}
+		}
+	}
+
+	s.log.Debug(fmt.Sprintf("Retransmitted %d packets to %s", count, target))
+}
+
+// protect send a parity packet once enough packets have been sent since last one
+func (s *Streamer) protect(msg proto.Message) {
+	if s.sb.Config.Streamer.FECGroup <= 0 {
+		return
+	}
+
+	s.protected = append(s.protected, msg)
+	if len(s.protected) < s.sb.Config.Streamer.FECGroup {
+		return
+	}
+
+	parity, err := codec.NewParity(s.protected)
+	s.protected = s.protected[:0]
+	if err != nil {
+		s.log.Warn("Unable to compute parity packet: ", err)
+		return
+	}
+
+	parityData, _ := message.ToBuffer(parity)
+	s.Messenger.Message <- &message.WriteRequest{DeviceName: "*", Message: parityData, Multicast: true}
+}
+
+// newStreamID random non zero identifier
+func newStreamID() uint32 {
+	var id [4]byte
+	for binary.BigEndian.Uint32(id[:]) == 0 {
+		_, _ = rand.Read(id[:])
+	}
+
+	return binary.BigEndian.Uint32(id[:])
 }
 
 type streamable interface {
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/faiface/beep"
+	"github.com/faiface/beep/wav"
+	"github.com/tuarrep/sounddrop/codec"
+	"github.com/tuarrep/sounddrop/message"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// wavFile build a stereo PCM wav file holding given integer samples
+func wavFile(bits int, samples [][2]int32) []byte {
+	width := bits / 8
+	size := len(samples) * 2 * width
+
+	var buffer bytes.Buffer
+	buffer.WriteString("RIFF")
+	binary.Write(&buffer, binary.LittleEndian, uint32(36+size))
+	buffer.WriteString("WAVEfmt ")
+	binary.Write(&buffer, binary.LittleEndian, uint32(16))
+	binary.Write(&buffer, binary.LittleEndian, uint16(1))
+	binary.Write(&buffer, binary.LittleEndian, uint16(2))
+	binary.Write(&buffer, binary.LittleEndian, uint32(44100))
+	binary.Write(&buffer, binary.LittleEndian, uint32(44100*2*width))
+	binary.Write(&buffer, binary.LittleEndian, uint16(2*width))
+	binary.Write(&buffer, binary.LittleEndian, uint16(bits))
+	buffer.WriteString("data")
+	binary.Write(&buffer, binary.LittleEndian, uint32(size))
+
+	for _, sample := range samples {
+		for _, value := range sample {
+			for b := 0; b < width; b++ {
+				buffer.WriteByte(byte(value >> uint(8*b)))
+			}
+		}
+	}
+
+	return buffer.Bytes()
+}
+
+func TestFlacStreamRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	cases := map[string]func(i int, channel int, max int32) int32{
+		"random": func(i int, channel int, max int32) int32 {
+			return int32(random.Int63n(2*int64(max)+2)) - max - 1
+		},
+		"silence": func(i int, channel int, max int32) int32 {
+			return 0
+		},
+		"full scale": func(i int, channel int, max int32) int32 {
+			if (i+channel)%2 == 0 {
+				return max
+			}
+			return -max - 1
+		},
+	}
+
+	formats := map[message.SampleFormat]int{
+		message.SampleFormat_INT16: 16,
+		message.SampleFormat_INT24: 24,
+	}
+
+	for format, bits := range formats {
+		for name, value := range cases {
+			encoder, err := codec.NewEncoder(message.AudioCodec_FLAC, codec.EncoderOptions{SampleFormat: format})
+			if err != nil {
+				t.Fatalf("%s %d bits: %v", name, bits, err)
+			}
+
+			// Last frame is shorter than the others
+			max := int32(1)<<uint(bits-1) - 1
+			samples := make([][2]int32, 2*encoder.FrameSize()+100)
+			for i := range samples {
+				samples[i] = [2]int32{value(i, 0, max), value(i, 1, max)}
+			}
+
+			stream, _, err := wav.Decode(ioutil.NopCloser(bytes.NewReader(wavFile(bits, samples))))
+			if err != nil {
+				t.Fatalf("%s %d bits: wav: %v", name, bits, err)
+			}
+
+			s := &Streamer{encoder: encoder, audioCodec: message.AudioCodec_FLAC, sampleFormat: format}
+			p := &Player{format: beep.Format{SampleRate: 44100, NumChannels: 2, Precision: 2}}
+			ps := &playerStream{decoders: make(map[message.AudioCodec]codec.Decoder)}
+
+			frame := make([][2]float64, encoder.FrameSize())
+			for {
+				n, _ := stream.Stream(frame)
+				if n == 0 {
+					break
+				}
+
+				msg, err := s.encode(frame[:n], 0)
+				if err != nil {
+					t.Fatalf("%s %d bits: encode: %v", name, bits, err)
+				}
+
+				decoded, err := p.decode(ps, msg)
+				if err != nil {
+					t.Fatalf("%s %d bits: decode: %v", name, bits, err)
+				}
+
+				if len(decoded) != n {
+					t.Fatalf("%s %d bits: decoded %d samples, want %d", name, bits, len(decoded), n)
+				}
+
+				for i := range decoded {
+					if decoded[i] != frame[i] {
+						t.Fatalf("%s %d bits: sample %d is %v, want %v", name, bits, i, decoded[i], frame[i])
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestRememberNackBounded(t *testing.T) {
+	s := &Streamer{lastNacks: make(map[string]time.Time)}
+
+	for i := 0; i < maxNackSenders; i++ {
+		if !s.rememberNack(fmt.Sprint("player", i)) {
+			t.Fatalf("no room for player %d", i)
+		}
+	}
+	if s.rememberNack("late") {
+		t.Error("recent requests forgotten to make room")
+	}
+	if !s.rememberNack("player0") {
+		t.Error("known player refused")
+	}
+
+	for peer := range s.lastNacks {
+		s.lastNacks[peer] = time.Now().Add(-retransmitInterval)
+	}
+	if !s.rememberNack("late") {
+		t.Error("old requests kept instead of making room")
+	}
+	if len(s.lastNacks) != 1 {
+		t.Errorf("%d requests remembered, want 1", len(s.lastNacks))
+	}
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"fmt"
+	"github.com/tuarrep/sounddrop/codec"
+	"github.com/tuarrep/sounddrop/message"
+	"net"
+)
+
+// Largest parity group accepted from network
+const maxParityGroup = 256
+
+// Largest number of missing packet ranges in a retransmission request
+const maxNackRanges = 64
+
+// validatePacket check a message received from network is consistent before handing it to other services
+func validatePacket(packet *message.Packet) error {
+	if packet.Envelope.Sender == "" {
+		return fmt.Errorf("missing sender")
+	}
+
+	switch m := packet.Message.(type) {
+	case *message.Announce:
+		if m.DeviceName == "" {
+			return fmt.Errorf("announce without device name")
+		}
+		if m.MulticastGroup != "" && net.ParseIP(m.MulticastGroup) == nil {
+			return fmt.Errorf("invalid multicast group %q", m.MulticastGroup)
+		}
+		if len(m.PublicKey) != ed25519.PublicKeySize || len(m.Signature) != ed25519.SignatureSize {
+			return fmt.Errorf("unsigned announce")
+		}
+	case *message.PeerList:
+		if len(m.Peers) > maxPeerList {
+			return fmt.Errorf("peer list of %d peers is too long", len(m.Peers))
+		}
+		for _, info := range m.Peers {
+			if info.Id == "" {
+				return fmt.Errorf("peer without id in peer list")
+			}
+			// Host names would be resolved by server goroutine, lists only carry addresses
+			if host, _, err := net.SplitHostPort(info.Address); err != nil || net.ParseIP(host) == nil {
+				return fmt.Errorf("invalid peer address %q", info.Address)
+			}
+		}
+	case *message.DeviceStatus:
+		if m.Id == "" {
+			return fmt.Errorf("device status without device id")
+		}
+		if len(m.PublicKey) != ed25519.PublicKeySize || len(m.Signature) != ed25519.SignatureSize {
+			return fmt.Errorf("unsigned device status")
+		}
+	case *message.PairChallenge:
+		if len(m.Nonce) == 0 || len(m.Nonce) > 64 {
+			return fmt.Errorf("invalid pairing nonce of %d bytes", len(m.Nonce))
+		}
+		if len(m.Proof) != sha256.Size {
+			return fmt.Errorf("invalid pairing proof of %d bytes", len(m.Proof))
+		}
+	case *message.PairResponse:
+		if len(m.Proof) != sha256.Size {
+			return fmt.Errorf("invalid pairing proof of %d bytes", len(m.Proof))
+		}
+	case *message.ClockResponse:
+		if m.Transmit < m.Receive {
+			return fmt.Errorf("clock response transmitted before reception")
+		}
+	case *message.StreamData:
+		return validateStreamData(m)
+	case *message.EncodedStreamData:
+		if err := validateCodec(m.Codec); err != nil {
+			return err
+		}
+		if len(m.Frame) == 0 {
+			return fmt.Errorf("empty encoded frame")
+		}
+	case *message.StreamParity:
+		return validateStreamParity(m)
+	case *message.StreamNack:
+		if m.DeviceName == "" {
+			return fmt.Errorf("stream nack without device name")
+		}
+		if len(m.Missing) > maxNackRanges {
+			return fmt.Errorf("stream nack with %d missing ranges", len(m.Missing))
+		}
+		for _, missing := range m.Missing {
+			if missing.First > missing.Last || missing.Last-missing.First >= retransmitHistory {
+				return fmt.Errorf("invalid missing range %d-%d", missing.First, missing.Last)
+			}
+		}
+	case *message.Sealed:
+		if len(m.Ciphertext) < sealTagSize {
+			return fmt.Errorf("sealed message of %d bytes is truncated", len(m.Ciphertext))
+		}
+	case *message.Handshake:
+		if m.Stage > 3 {
+			return fmt.Errorf("invalid handshake stage %d", m.Stage)
+		}
+	case *message.Fragment:
+		if m.Count == 0 || m.Index >= m.Count || m.Count > maxFragments {
+			return fmt.Errorf("invalid fragment %d/%d", m.Index, m.Count)
+		}
+	}
+
+	return nil
+}
+
+func validateStreamData(m *message.StreamData) error {
+	if _, known := message.SampleFormat_name[int32(m.SampleFormat)]; !known {
+		return fmt.Errorf("unknown sample format %d", m.SampleFormat)
+	}
+
+	if len(m.SamplesLeft) != len(m.SamplesRight) {
+		return fmt.Errorf("left and right channels lengths mismatch (%d != %d)", len(m.SamplesLeft), len(m.SamplesRight))
+	}
+
+	if len(m.Samples)%codec.FrameSize(m.SampleFormat) != 0 {
+		return fmt.Errorf("truncated %v samples (%d bytes)", m.SampleFormat, len(m.Samples))
+	}
+
+	return nil
+}
+
+func validateStreamParity(m *message.StreamParity) error {
+	if len(m.Lengths) == 0 || len(m.Lengths) > maxParityGroup {
+		return fmt.Errorf("invalid parity group of %d frames", len(m.Lengths))
+	}
+
+	if len(m.NextAts) != len(m.Lengths) {
+		return fmt.Errorf("parity group of %d frames has %d timestamps", len(m.Lengths), len(m.NextAts))
+	}
+
+	for _, length := range m.Lengths {
+		if int(length) > len(m.Parity) {
+			return fmt.Errorf("parity frame length %d exceeds parity length %d", length, len(m.Parity))
+		}
+	}
+
+	if m.Codec != message.AudioCodec_NO_CODEC {
+		return validateCodec(m.Codec)
+	}
+
+	if _, known := message.SampleFormat_name[int32(m.SampleFormat)]; !known {
+		return fmt.Errorf("unknown sample format %d", m.SampleFormat)
+	}
+
+	return nil
+}
+
+func validateCodec(audioCodec message.AudioCodec) error {
+	if _, known := message.AudioCodec_name[int32(audioCodec)]; !known || audioCodec == message.AudioCodec_NO_CODEC {
+		return fmt.Errorf("unknown codec %d", audioCodec)
+	}
+
+	return nil
+}
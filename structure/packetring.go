@@ -0,0 +1,59 @@
+package structure
+
+import "sync"
+
+// PacketRing keeps the last packets of a stream indexed by their sequence number
+type PacketRing struct {
+	packets   [][]byte
+	sequences []uint64
+	newest    uint64
+	stored    int
+	mutex     sync.RWMutex
+}
+
+// Put store a packet, replacing the oldest one when the ring is full
+func (r *PacketRing) Put(sequence uint64, packet []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	index := sequence % uint64(len(r.packets))
+	r.packets[index] = packet
+	r.sequences[index] = sequence
+
+	if r.stored == 0 || sequence > r.newest {
+		r.newest = sequence
+	}
+	if r.stored < len(r.packets) {
+		r.stored++
+	}
+}
+
+// Get a packet by its sequence number if it is still in the ring
+func (r *PacketRing) Get(sequence uint64) ([]byte, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	index := sequence % uint64(len(r.packets))
+	if r.packets[index] == nil || r.sequences[index] != sequence {
+		return nil, false
+	}
+
+	return r.packets[index], true
+}
+
+// Span first and last sequence numbers the ring can hold packets for, false when it is empty
+func (r *PacketRing) Span() (first uint64, last uint64, ok bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if r.stored == 0 {
+		return 0, 0, false
+	}
+
+	return r.newest - uint64(r.stored-1), r.newest, true
+}
+
+// NewPacketRing creates a new ring of specified size
+func NewPacketRing(size int) *PacketRing {
+	return &PacketRing{packets: make([][]byte, size), sequences: make([]uint64, size)}
+}
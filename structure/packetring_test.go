@@ -0,0 +1,69 @@
+package structure
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPacketRingGet(t *testing.T) {
+	r := NewPacketRing(4)
+
+	if _, found := r.Get(0); found {
+		t.Error("packet found in an empty ring")
+	}
+
+	for sequence := uint64(10); sequence < 13; sequence++ {
+		r.Put(sequence, []byte{byte(sequence)})
+	}
+
+	for sequence := uint64(10); sequence < 13; sequence++ {
+		packet, found := r.Get(sequence)
+		if !found || !bytes.Equal(packet, []byte{byte(sequence)}) {
+			t.Errorf("packet %d is %v (found %v)", sequence, packet, found)
+		}
+	}
+
+	if _, found := r.Get(14); found {
+		t.Error("packet never stored found")
+	}
+}
+
+func TestPacketRingOverwrite(t *testing.T) {
+	r := NewPacketRing(4)
+
+	for sequence := uint64(0); sequence < 6; sequence++ {
+		r.Put(sequence, []byte{byte(sequence)})
+	}
+
+	for _, sequence := range []uint64{0, 1} {
+		if _, found := r.Get(sequence); found {
+			t.Errorf("overwritten packet %d found", sequence)
+		}
+	}
+	for _, sequence := range []uint64{2, 3, 4, 5} {
+		if _, found := r.Get(sequence); !found {
+			t.Errorf("packet %d lost", sequence)
+		}
+	}
+}
+
+func TestPacketRingSpan(t *testing.T) {
+	r := NewPacketRing(4)
+
+	if _, _, ok := r.Span(); ok {
+		t.Error("empty ring has a span")
+	}
+
+	r.Put(7, []byte{7})
+	r.Put(8, []byte{8})
+	if first, last, ok := r.Span(); !ok || first != 7 || last != 8 {
+		t.Errorf("span is %d-%d (%v), want 7-8", first, last, ok)
+	}
+
+	for sequence := uint64(9); sequence < 20; sequence++ {
+		r.Put(sequence, []byte{byte(sequence)})
+	}
+	if first, last, ok := r.Span(); !ok || first != 16 || last != 19 {
+		t.Errorf("span is %d-%d (%v), want 16-19", first, last, ok)
+	}
+}
@@ -0,0 +1,60 @@
+package structure
+
+// Number of sequence numbers remembered before the most recent one
+const sequenceWindow = 64
+
+// SequenceTracker follows sequence numbers of a packet stream to detect lost, reordered and duplicated packets
+type SequenceTracker struct {
+	next     uint64
+	started  bool
+	received uint64
+
+	Lost       uint64
+	Reordered  uint64
+	Duplicated uint64
+}
+
+// Track register a received sequence number. It returns the number of packets missing just before it
+// and whether the packet should be processed (late and duplicated packets should not)
+func (t *SequenceTracker) Track(sequence uint64) (missing uint64, accept bool) {
+	if !t.started {
+		t.started = true
+		t.next = sequence + 1
+		t.received = 1
+		return 0, true
+	}
+
+	if sequence >= t.next {
+		missing = sequence - t.next
+		shift := missing + 1
+		if shift >= sequenceWindow {
+			t.received = 0
+		} else {
+			t.received <<= shift
+		}
+		t.received |= 1
+		t.next = sequence + 1
+		t.Lost += missing
+		return missing, true
+	}
+
+	age := t.next - 1 - sequence
+	if age >= sequenceWindow {
+		t.Reordered++
+		return 0, false
+	}
+
+	if t.received&(1<<age) != 0 {
+		t.Duplicated++
+		return 0, false
+	}
+
+	t.received |= 1 << age
+	t.Reordered++
+	return 0, false
+}
+
+// Next sequence number expected and whether any packet has been tracked yet
+func (t *SequenceTracker) Next() (uint64, bool) {
+	return t.next, t.started
+}
@@ -0,0 +1,67 @@
+package structure
+
+import "testing"
+
+func TestSequenceTrackerInOrder(t *testing.T) {
+	var tracker SequenceTracker
+
+	if _, started := tracker.Next(); started {
+		t.Error("tracker started before any packet")
+	}
+
+	for sequence := uint64(5); sequence < 10; sequence++ {
+		if missing, accept := tracker.Track(sequence); missing != 0 || !accept {
+			t.Errorf("packet %d: missing %d, accepted %v", sequence, missing, accept)
+		}
+	}
+
+	if next, started := tracker.Next(); !started || next != 10 {
+		t.Errorf("next is %d (started %v), want 10", next, started)
+	}
+	if tracker.Lost != 0 || tracker.Reordered != 0 || tracker.Duplicated != 0 {
+		t.Errorf("counted %d lost, %d reordered, %d duplicated", tracker.Lost, tracker.Reordered, tracker.Duplicated)
+	}
+}
+
+func TestSequenceTrackerLoss(t *testing.T) {
+	var tracker SequenceTracker
+
+	tracker.Track(0)
+	if missing, accept := tracker.Track(4); missing != 3 || !accept {
+		t.Errorf("missing %d, accepted %v, want 3 missing", missing, accept)
+	}
+	if tracker.Lost != 3 {
+		t.Errorf("%d packets lost, want 3", tracker.Lost)
+	}
+}
+
+func TestSequenceTrackerLateAndDuplicated(t *testing.T) {
+	var tracker SequenceTracker
+
+	tracker.Track(0)
+	tracker.Track(3)
+
+	if _, accept := tracker.Track(2); accept {
+		t.Error("late packet accepted")
+	}
+	if tracker.Reordered != 1 {
+		t.Errorf("%d packets reordered, want 1", tracker.Reordered)
+	}
+
+	for _, sequence := range []uint64{2, 3} {
+		if _, accept := tracker.Track(sequence); accept {
+			t.Errorf("duplicated packet %d accepted", sequence)
+		}
+	}
+	if tracker.Duplicated != 2 {
+		t.Errorf("%d packets duplicated, want 2", tracker.Duplicated)
+	}
+
+	tracker.Track(3 + sequenceWindow)
+	if _, accept := tracker.Track(1); accept {
+		t.Error("packet older than window accepted")
+	}
+	if tracker.Reordered != 2 {
+		t.Errorf("%d packets reordered, want 2", tracker.Reordered)
+	}
+}
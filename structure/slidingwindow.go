@@ -0,0 +1,36 @@
+package structure
+
+// Number of sequence numbers remembered before the highest one
+const slidingWindowSize = 64
+
+// SlidingWindow remember which of the most recent sequence numbers have been seen, to drop duplicated packets
+type SlidingWindow struct {
+	highest uint64
+	seen    uint64
+	started bool
+}
+
+// Check register a sequence number and tell whether it is seen for the first time.
+// Sequence numbers too old to be remembered are considered seen
+func (w *SlidingWindow) Check(sequence uint64) bool {
+	if !w.started || sequence > w.highest {
+		shift := sequence - w.highest
+		if !w.started || shift >= slidingWindowSize {
+			w.seen = 0
+		} else {
+			w.seen <<= shift
+		}
+		w.seen |= 1
+		w.highest = sequence
+		w.started = true
+		return true
+	}
+
+	age := w.highest - sequence
+	if age >= slidingWindowSize || w.seen&(1<<age) != 0 {
+		return false
+	}
+
+	w.seen |= 1 << age
+	return true
+}
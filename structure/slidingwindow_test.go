@@ -0,0 +1,79 @@
+package structure
+
+import "testing"
+
+func TestSlidingWindowFirstSequence(t *testing.T) {
+	var w SlidingWindow
+
+	if !w.Check(0) {
+		t.Error("first sequence 0 taken as seen")
+	}
+	if w.Check(0) {
+		t.Error("sequence 0 accepted twice")
+	}
+
+	w = SlidingWindow{}
+	if !w.Check(1000) {
+		t.Error("first sequence 1000 taken as seen")
+	}
+}
+
+func TestSlidingWindowDuplicates(t *testing.T) {
+	var w SlidingWindow
+
+	for sequence := uint64(1); sequence <= 10; sequence++ {
+		if !w.Check(sequence) {
+			t.Errorf("new sequence %d taken as seen", sequence)
+		}
+	}
+
+	for sequence := uint64(1); sequence <= 10; sequence++ {
+		if w.Check(sequence) {
+			t.Errorf("duplicated sequence %d accepted", sequence)
+		}
+	}
+}
+
+func TestSlidingWindowReordering(t *testing.T) {
+	var w SlidingWindow
+
+	w.Check(100)
+	for _, sequence := range []uint64{90, 99, 37, 95} {
+		if !w.Check(sequence) {
+			t.Errorf("reordered sequence %d taken as seen", sequence)
+		}
+		if w.Check(sequence) {
+			t.Errorf("reordered sequence %d accepted twice", sequence)
+		}
+	}
+}
+
+func TestSlidingWindowTooOld(t *testing.T) {
+	var w SlidingWindow
+
+	w.Check(100)
+	if w.Check(100 - slidingWindowSize) {
+		t.Error("sequence older than window accepted")
+	}
+	if !w.Check(100 - slidingWindowSize + 1) {
+		t.Error("oldest sequence of window taken as seen")
+	}
+}
+
+func TestSlidingWindowJump(t *testing.T) {
+	var w SlidingWindow
+
+	for sequence := uint64(1); sequence <= 10; sequence++ {
+		w.Check(sequence)
+	}
+
+	if !w.Check(10 + 10*slidingWindowSize) {
+		t.Error("sequence far ahead taken as seen")
+	}
+	if !w.Check(11 + 9*slidingWindowSize) {
+		t.Error("unseen sequence after a jump taken as seen")
+	}
+	if w.Check(10) {
+		t.Error("sequence before a jump accepted")
+	}
+}
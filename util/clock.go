@@ -0,0 +1,45 @@
+package util
+
+import (
+	"time"
+)
+
+// Wall clock is read once at start. Its monotonic reading is used from then on, so NTP steps and manual changes do not move device clock
+var clockOrigin = time.Now()
+
+// Now current time of device clock, in nanoseconds since unix epoch. Unlike wall clock it never jumps
+func Now() int64 {
+	return clockOrigin.UnixNano() + time.Since(clockOrigin).Nanoseconds()
+}
+
+// MeshClock clock of another device relative to ours, as measured by clock synchronization.
+// A nil MeshClock is a clock not synchronized yet, assumed to be ours
+type MeshClock struct {
+	Offset    int64
+	Drift     float64
+	Reference int64
+}
+
+// FromLocal convert a time of our clock to remote clock
+func (c *MeshClock) FromLocal(local int64) int64 {
+	if c == nil {
+		return local
+	}
+
+	return local + c.Offset + int64(c.Drift*float64(local-c.Reference))
+}
+
+// ToLocal convert a time of remote clock to our clock
+func (c *MeshClock) ToLocal(remote int64) int64 {
+	if c == nil {
+		return remote
+	}
+
+	// Drift is tiny, remote time minus offset is close enough to the local time drift applies at
+	return remote - c.Offset - int64(c.Drift*float64(remote-c.Offset-c.Reference))
+}
+
+// Now current time of remote clock
+func (c *MeshClock) Now() int64 {
+	return c.FromLocal(Now())
+}
@@ -1,6 +1,9 @@
 package util
 
-import "flag"
+import (
+	"flag"
+	"strings"
+)
 
 // Config store the application
 type Config struct {
@@ -11,12 +14,25 @@ type Config struct {
 
 // DiscoverConfig peer discovering config
 type DiscoverConfig struct {
-	Port int
+	Port              int
+	MulticastGroup    string
+	MTU               int
+	Interfaces        []string
+	ExcludeInterfaces []string
+	DNSSD             bool
+	Peers             []string
+	Seeds             []string
+	Relay             bool
 }
 
 // MeshConfig mesh network config
 type MeshConfig struct {
 	AutoAccept bool
+	Console    string
+	Encrypt    bool
+	ID         string
+	Key        string
+	Trusted    []string
 }
 
 // StreamerConfig streamer config
@@ -25,26 +41,63 @@ type StreamerConfig struct {
 	PlaylistDir       string
 	ResamplingRate    int
 	ResamplingQuality int
+	SampleFormat      string
+	Codec             string
+	OpusBitrate       int
+	FECGroup          int
+	RTPAddress        string
+	RTPSDP            string
 }
 
 // InitConfig load config from flags
 func InitConfig() *Config {
 	discoverPort := flag.Int("port", 19416, "Server port")
+	mtu := flag.Int("mtu", 1400, "Largest datagram (bytes) sent on network, larger messages are fragmented")
+	interfaces := flag.String("interfaces", "", "Comma separated network interfaces used to discover devices (empty for all but loopback)")
+	excludeInterfaces := flag.String("exclude-interfaces", "", "Comma separated network interfaces never used to discover devices")
+	dnssd := flag.Bool("dnssd", false, "Publish device and discover others with DNS-SD (mDNS)")
+	peers := flag.String("peers", "", "Comma separated static peers (host:port) announced to directly, to reach devices on other subnets")
+	seeds := flag.String("seeds", "", "Comma separated seed peers (host:port) announced to directly and exchanging their known peers")
+	relay := flag.Bool("relay", false, "Forward audio and mesh state between the network segments this device is connected to")
+	multicastGroup := flag.String("multicast-group", "", "IPv4 multicast group used to send and receive audio (empty to send audio to each peer)")
 
 	autoAccept := flag.Bool("auto-accept", false, "Auto accept discovered devices")
+	meshKey := flag.String("mesh-key", "", "Secret shared by mesh devices to authenticate every packet (empty disables authentication)")
+	encrypt := flag.Bool("encrypt", false, "Encrypt audio and mesh messages with keys negotiated between devices (needs -mesh-key, audio is sent to each peer)")
+	console := flag.String("console", "", "Address (host:port) of the console used to pair devices, keep it on localhost (empty disables console)")
+	trusted := flag.String("trusted", "", "Comma separated fingerprints of devices accepted in mesh")
+	meshID := flag.String("mesh-id", "", "Identifier of the mesh to join, devices of other meshes are ignored (empty for default mesh)")
 
 	autoStartStream := flag.Bool("auto-start-stream", false, "Auto start audio stream")
 	playlistDir := flag.String("playlist-dir", ".", "Directory containing audio files to play")
-	resamplingRate := flag.Int("resampling-rate", 44100, "Frequency (Hz) to use to normalize file sample rate")
+	resamplingRate := flag.Int("resampling-rate", 44100, "Frequency (Hz) to use to normalize file sample rate and to play audio")
 	resamplingQuality := flag.Int("resampling-quality", 3, "Quality of resampling process")
+	sampleFormat := flag.String("sample-format", "int16", "Sample format used to stream audio (int16, int24, float32 or double)")
+	codec := flag.String("codec", "pcm", "Codec used to stream audio (pcm, opus or flac)")
+	opusBitrate := flag.Int("opus-bitrate", 128000, "Bitrate (bit/s) of opus encoded stream")
+	rtpAddress := flag.String("rtp-address", "", "Address (host:port) receiving a RTP copy of the audio stream, RTCP uses next port (empty disables RTP output)")
+	rtpSDP := flag.String("rtp-sdp", "", "File where the SDP description of the RTP stream is written (empty to not write it)")
+	fecGroup := flag.Int("fec-group", 0, "Number of audio packets protected by one parity packet (0 disables forward error correction)")
 
 	flag.Parse()
 
-	discoverConfig := &DiscoverConfig{Port: *discoverPort}
-	meshConfig := &MeshConfig{AutoAccept: *autoAccept}
-	streamerConfig := &StreamerConfig{AutoStart: *autoStartStream, PlaylistDir: *playlistDir, ResamplingRate: *resamplingRate, ResamplingQuality: *resamplingQuality}
+	discoverConfig := &DiscoverConfig{Port: *discoverPort, MulticastGroup: *multicastGroup, MTU: *mtu, Interfaces: splitList(*interfaces), ExcludeInterfaces: splitList(*excludeInterfaces), DNSSD: *dnssd, Peers: splitList(*peers), Seeds: splitList(*seeds), Relay: *relay}
+	meshConfig := &MeshConfig{AutoAccept: *autoAccept, Console: *console, Encrypt: *encrypt, ID: *meshID, Key: *meshKey, Trusted: splitList(*trusted)}
+	streamerConfig := &StreamerConfig{AutoStart: *autoStartStream, PlaylistDir: *playlistDir, ResamplingRate: *resamplingRate, ResamplingQuality: *resamplingQuality, SampleFormat: *sampleFormat, Codec: *codec, OpusBitrate: *opusBitrate, FECGroup: *fecGroup, RTPAddress: *rtpAddress, RTPSDP: *rtpSDP}
 
 	config := &Config{Discover: discoverConfig, Mesh: meshConfig, Streamer: streamerConfig}
 
 	return config
 }
+
+// splitList split a comma separated flag value, ignoring empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,102 @@
+package util
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/shibukawa/configdir"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Name of the file holding device private key in config dir
+const identityFile = ".identity"
+
+// Namespace device IDs are derived from public keys in
+var identityNamespace = uuid.MustParse("6f1b3a52-94d1-4c3e-8a57-2d0e9b7c4f18")
+
+// Identity device Ed25519 keypair, device ID is derived from its public key
+type Identity struct {
+	PublicKey  ed25519.PublicKey
+	privateKey ed25519.PrivateKey
+}
+
+// GetMyIdentity load device keypair from config dir, generating it on first start
+func GetMyIdentity() (*Identity, error) {
+	configDirs := configdir.New("sounddrop", "sounddrop")
+	config := configDirs.QueryFolders(configdir.Global)[0]
+	filePath := fmt.Sprintf("%s/%s", config.Path, identityFile)
+
+	if data, err := ioutil.ReadFile(filePath); err == nil {
+		seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid device key in %s", filePath)
+		}
+
+		return newIdentity(ed25519.NewKeyFromSeed(seed)), nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.MkdirAll(); err != nil {
+		return nil, err
+	}
+
+	// Private key gives the device identity away, only its owner may read it
+	if err := ioutil.WriteFile(filePath, []byte(hex.EncodeToString(privateKey.Seed())), 0600); err != nil {
+		return nil, err
+	}
+
+	return newIdentity(privateKey), nil
+}
+
+func newIdentity(privateKey ed25519.PrivateKey) *Identity {
+	return &Identity{PublicKey: privateKey.Public().(ed25519.PublicKey), privateKey: privateKey}
+}
+
+// DeviceID device mesh Id, derived from public key
+func (i *Identity) DeviceID() uuid.UUID {
+	return DeviceIDOf(i.PublicKey)
+}
+
+// Fingerprint short form of public key users compare to pair devices
+func (i *Identity) Fingerprint() string {
+	return Fingerprint(i.PublicKey)
+}
+
+// Sign sign data with device private key
+func (i *Identity) Sign(data []byte) []byte {
+	return ed25519.Sign(i.privateKey, data)
+}
+
+// DeviceIDOf device ID owning a public key
+func DeviceIDOf(publicKey ed25519.PublicKey) uuid.UUID {
+	return uuid.NewSHA1(identityNamespace, publicKey)
+}
+
+// Fingerprint short form of a public key, 80 bits of its SHA-256 as four groups of base32 characters
+func Fingerprint(publicKey ed25519.PublicKey) string {
+	hash := sha256.Sum256(publicKey)
+	encoded := base32.StdEncoding.EncodeToString(hash[:10])
+
+	return fmt.Sprintf("%s-%s-%s-%s", encoded[0:4], encoded[4:8], encoded[8:12], encoded[12:16])
+}
+
+// SameFingerprint compare fingerprints as typed by users, ignoring case and separators
+func SameFingerprint(a string, b string) bool {
+	normalize := func(fingerprint string) string {
+		return strings.NewReplacer("-", "", " ", "", ":", "").Replace(strings.ToUpper(fingerprint))
+	}
+
+	return normalize(a) == normalize(b)
+}
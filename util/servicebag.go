@@ -7,6 +7,7 @@ import (
 // ServiceBag various global object injected to services
 type ServiceBag struct {
 	DeviceID uuid.UUID
+	Identity *Identity
 	Config   *Config
 }
 